@@ -4,6 +4,11 @@ import (
 	"github.com/Fi44er/btc_bot/config"
 	"github.com/Fi44er/btc_bot/db"
 	"github.com/Fi44er/btc_bot/internal/bot"
+	"github.com/Fi44er/btc_bot/internal/chain"
+	"github.com/Fi44er/btc_bot/internal/fees"
+	"github.com/Fi44er/btc_bot/internal/limits"
+	"github.com/Fi44er/btc_bot/internal/pricing"
+	"github.com/Fi44er/btc_bot/internal/rates"
 	"github.com/Fi44er/btc_bot/internal/repository"
 	"github.com/Fi44er/btc_bot/internal/service"
 	"github.com/Fi44er/btc_bot/utils"
@@ -27,7 +32,34 @@ func main() {
 	}
 
 	repo := repository.NewRepository(database, logger)
-	userService, err := service.NewUserService(repo, cfg.MasterKeySeed, cfg.AdminChatID, &cfg, logger)
+	chainBackend := chain.NewMempool(cfg.ChainAPIURL)
+
+	rateSources, err := rates.SourcesByName(cfg.RateSources)
+	if err != nil {
+		logger.Fatal("Failed to configure rate sources: ", err)
+	}
+	rateProvider := rates.NewCached(
+		rates.NewMedian(rateSources, cfg.RateMaxDeviationPercent/100, logger),
+		cfg.RateCacheTTL,
+	)
+
+	prices, err := pricing.New(cfg.BuySpreadPercent, cfg.VIPSpreadPercent, cfg.BuySpreadTiers)
+	if err != nil {
+		logger.Fatal("Failed to configure pricing: ", err)
+	}
+
+	withdrawalFees, err := fees.New(cfg.WithdrawalFees, cfg.WithdrawalMinFees, cfg.SupportedCurrencies)
+	if err != nil {
+		logger.Fatal("Failed to configure withdrawal fees: ", err)
+	}
+
+	withdrawalLimits, err := limits.New(cfg.WithdrawalMinAmount, cfg.WithdrawalMaxAmount,
+		cfg.WithdrawalUserLimits, cfg.WithdrawalGlobalLimits, cfg.CardChangeCooldown, cfg.SupportedCurrencies)
+	if err != nil {
+		logger.Fatal("Failed to configure withdrawal limits: ", err)
+	}
+
+	userService, err := service.NewUserService(repo, chainBackend, rateProvider, prices, withdrawalFees, withdrawalLimits, cfg.MasterKeySeed, cfg.AdminChatID, &cfg, logger)
 	if err != nil {
 		logger.Fatal("Failed to create user service: ", err)
 	}
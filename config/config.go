@@ -3,7 +3,11 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/spf13/viper"
 )
 
@@ -12,6 +16,72 @@ type Config struct {
 	AdminChatID      int64  `mapstructure:"ADMIN_CHAT_ID"`
 	MasterKeySeed    string `mapstructure:"MASTER_KEY_SEED"`
 	DB_URL           string `mapstructure:"DB_URL"`
+	ChainAPIURL      string `mapstructure:"CHAIN_API_URL"`
+	// Ссылка на транзакцию в обозревателе блоков, к ней дописывается txid
+	ExplorerTxURL string `mapstructure:"EXPLORER_TX_URL"`
+
+	// Сколько подтверждений нужно, чтобы зачислить пополнение
+	MinConfirmations int64 `mapstructure:"MIN_CONFIRMATIONS"`
+	// Как часто пользователь может вручную запускать проверку пополнения
+	ManualCheckInterval time.Duration `mapstructure:"MANUAL_CHECK_INTERVAL"`
+
+	// Минимальная сумма к зачислению в сатоши: меньшие поступления копятся, пока не превысят порог
+	MinDepositSats btcutil.Amount `mapstructure:"MIN_DEPOSIT_SATS"`
+	// Поступления меньше этого порога (в сатоши) считаются пылью и не зачисляются вовсе
+	DustLimitSats btcutil.Amount `mapstructure:"DUST_LIMIT_SATS"`
+
+	// Валюты, в которых пользователь может держать баланс, и валюта по умолчанию
+	SupportedCurrencies []string `mapstructure:"SUPPORTED_CURRENCIES"`
+	DefaultCurrency     string   `mapstructure:"DEFAULT_CURRENCY"`
+
+	// Источники курса BTC через запятую: binance, kraken, coingecko
+	RateSources []string `mapstructure:"RATE_SOURCES"`
+	// Курсы, отклоняющиеся от медианы больше чем на этот процент, отбрасываются
+	RateMaxDeviationPercent float64 `mapstructure:"RATE_MAX_DEVIATION_PERCENT"`
+	// Сколько времени полученный курс переиспользуется без обращения к источникам
+	RateCacheTTL time.Duration `mapstructure:"RATE_CACHE_TTL"`
+	// Насколько старым может быть сохранённый курс, если источники недоступны
+	RateMaxAge time.Duration `mapstructure:"RATE_MAX_AGE"`
+	// Как часто сохранять курс в историю
+	RateSnapshotInterval time.Duration `mapstructure:"RATE_SNAPSHOT_INTERVAL"`
+
+	// Спред при зачислении пополнений, % от рыночного курса
+	BuySpreadPercent float64 `mapstructure:"BUY_SPREAD_PERCENT"`
+	// Спред для VIP-пользователей, %
+	VIPSpreadPercent float64 `mapstructure:"VIP_SPREAD_PERCENT"`
+	// Уровни по обороту пополнений в валюте пользователя: "100000:2.5,1000000:1.5"
+	BuySpreadTiers string `mapstructure:"BUY_SPREAD_TIERS"`
+
+	// Сколько действует зафиксированный курс пополнения
+	QuoteTTL time.Duration `mapstructure:"QUOTE_TTL"`
+	// Допустимое отклонение суммы оплаты от котировки, %
+	QuoteTolerancePercent float64 `mapstructure:"QUOTE_TOLERANCE_PERCENT"`
+
+	// Как часто сверять пополнения в сети с базой, журналом и балансами; 0 — не сверять
+	ReconcileInterval time.Duration `mapstructure:"RECONCILE_INTERVAL"`
+
+	// Комиссии за вывод по способам выплаты и валютам: "card/RUB=0:1.5:50,100000:1:0" — с суммы от 0 ₽
+	// берётся 1.5% + 50 ₽, от 100000 ₽ — 1%; расписания разделяются ";". Расписание без валюты ("card=0:2:0")
+	// действует для остальных валют и может содержать только проценты. По умолчанию вывод на карту
+	// без комиссии — размер комиссии задаётся явно
+	WithdrawalFees string `mapstructure:"WITHDRAWAL_FEES"`
+	// Минимальные комиссии по способам выплаты и валютам: "card/RUB=100,card/USD=1"
+	WithdrawalMinFees string `mapstructure:"WITHDRAWAL_MIN_FEES"`
+
+	// Минимальная и максимальная сумма одной заявки на вывод по валютам: "RUB=500,USD=10";
+	// валюта без суммы — без ограничения
+	WithdrawalMinAmount string `mapstructure:"WITHDRAWAL_MIN_AMOUNT"`
+	WithdrawalMaxAmount string `mapstructure:"WITHDRAWAL_MAX_AMOUNT"`
+	// Лимиты суммы выводов с комиссией за скользящие сутки, неделю и месяц по валютам:
+	// "RUB:day=50000,week=200000,month=500000;USD:day=600". USER — для каждого пользователя,
+	// GLOBAL — для всех пользователей вместе в одной валюте
+	WithdrawalUserLimits   string `mapstructure:"WITHDRAWAL_USER_LIMITS"`
+	WithdrawalGlobalLimits string `mapstructure:"WITHDRAWAL_GLOBAL_LIMITS"`
+	// Сколько после смены номера карты нельзя создавать заявки на вывод; 0 — без паузы
+	CardChangeCooldown time.Duration `mapstructure:"CARD_CHANGE_COOLDOWN"`
+
+	// Уведомление пользователю о ручной корректировке баланса; подстановки {amount}, {currency}, {reason}, {balance}
+	AdjustmentMessage string `mapstructure:"ADJUSTMENT_MESSAGE"`
 }
 
 func LoadConfig(path string) (config Config, err error) {
@@ -25,6 +95,24 @@ func LoadConfig(path string) (config Config, err error) {
 	viper.SetConfigType("env")
 	viper.AutomaticEnv()
 
+	viper.SetDefault("CHAIN_API_URL", "https://mempool.space/api")
+	viper.SetDefault("EXPLORER_TX_URL", "https://mempool.space/tx/")
+	viper.SetDefault("MIN_CONFIRMATIONS", 1)
+	viper.SetDefault("SUPPORTED_CURRENCIES", "RUB,USD,EUR,KZT,UAH")
+	viper.SetDefault("DEFAULT_CURRENCY", "RUB")
+	viper.SetDefault("RATE_SOURCES", "binance,kraken,coingecko")
+	viper.SetDefault("RATE_MAX_DEVIATION_PERCENT", 3)
+	viper.SetDefault("RATE_CACHE_TTL", time.Minute)
+	viper.SetDefault("RATE_MAX_AGE", 30*time.Minute)
+	viper.SetDefault("RATE_SNAPSHOT_INTERVAL", 15*time.Minute)
+	viper.SetDefault("QUOTE_TTL", 30*time.Minute)
+	viper.SetDefault("QUOTE_TOLERANCE_PERCENT", 1)
+	viper.SetDefault("MANUAL_CHECK_INTERVAL", time.Minute)
+	viper.SetDefault("RECONCILE_INTERVAL", 24*time.Hour)
+	viper.SetDefault("WITHDRAWAL_FEES", "card=0:0:0")
+	viper.SetDefault("CARD_CHANGE_COOLDOWN", 24*time.Hour)
+	viper.SetDefault("ADJUSTMENT_MESSAGE", "ℹ️ Администратор скорректировал ваш баланс на {amount} {currency}.\nПричина: {reason}\nТекущий баланс: {balance} {currency}")
+
 	if err := viper.ReadInConfig(); err != nil {
 		return config, fmt.Errorf("ошибка чтения конфигурации: %w", err)
 	}
@@ -33,5 +121,26 @@ func LoadConfig(path string) (config Config, err error) {
 		return config, fmt.Errorf("ошибка преобразования конфига: %w", err)
 	}
 
+	config.DefaultCurrency = strings.ToUpper(strings.TrimSpace(config.DefaultCurrency))
+	var currencies []string
+	for _, currency := range config.SupportedCurrencies {
+		if currency = strings.ToUpper(strings.TrimSpace(currency)); currency != "" {
+			currencies = append(currencies, currency)
+		}
+	}
+	config.SupportedCurrencies = currencies
+	if !config.IsSupportedCurrency(config.DefaultCurrency) {
+		return config, fmt.Errorf("валюта по умолчанию %s не входит в SUPPORTED_CURRENCIES", config.DefaultCurrency)
+	}
+
+	// Неподтверждённые транзакции никогда не зачисляем
+	if config.MinConfirmations < 1 {
+		config.MinConfirmations = 1
+	}
+
 	return config, nil
 }
+
+func (c *Config) IsSupportedCurrency(currency string) bool {
+	return slices.Contains(c.SupportedCurrencies, currency)
+}
@@ -42,19 +42,41 @@ func Migrate(db *gorm.DB, trigger bool, log *utils.Logger) error {
 
 	if trigger {
 		log.Info("📦 Migrating database...")
-		models := []interface{}{
+		tables := []interface{}{
 			&models.SystemWallet{},
 			&models.User{},
 			&models.Transaction{},
 			&models.Withdrawal{},
+			&models.ExchangeRate{},
+			&models.Quote{},
+			&models.LedgerEntry{},
+			&models.LedgerPosting{},
+			&models.BalanceAdjustment{},
+			&models.Dispute{},
+			&models.DisputeMessage{},
+			&models.WithdrawalLimitOverride{},
+			&models.WithdrawalTransition{},
 		}
 
 		log.Info("📦 Creating types...")
 
-		if err := db.AutoMigrate(models...); err != nil {
+		backfills, err := prepareSchema(db)
+		if err != nil {
+			log.Errorf("✖ Failed to prepare database schema: %v", err)
+			return err
+		}
+
+		if err := db.AutoMigrate(tables...); err != nil {
 			log.Errorf("✖ Failed to migrate database: %v", err)
 			return err
 		}
+
+		for _, fill := range backfills {
+			if err := fill(db); err != nil {
+				log.Errorf("✖ Failed to backfill migrated data: %v", err)
+				return err
+			}
+		}
 	}
 
 	log.Info("✅ Database connection successfully")
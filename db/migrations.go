@@ -0,0 +1,246 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type backfill func(db *gorm.DB) error
+
+// prepareSchema вносит в существующую схему изменения, которые AutoMigrate сделать не может
+// (переименования колонок, удаление устаревших индексов), и возвращает заполнения данных,
+// которые нужно выполнить уже после AutoMigrate.
+func prepareSchema(db *gorm.DB) ([]backfill, error) {
+	m := db.Migrator()
+	var backfills []backfill
+
+	// До появления статусов подтверждённые транзакции зачислялись сразу,
+	// поэтому при добавлении колонки помечаем их как зачисленные.
+	if m.HasTable(&models.Transaction{}) && !m.HasColumn(&models.Transaction{}, "status") {
+		backfills = append(backfills, func(db *gorm.DB) error {
+			return db.Model(&models.Transaction{}).
+				Where("confirmed = ?", true).
+				Update("status", models.TransactionStatusCredited).
+				Error
+		})
+	}
+
+	// Суммы зачислений и котировок раньше хранились только в рублях
+	if m.HasColumn(&models.Transaction{}, "amount_rub") {
+		if err := m.RenameColumn(&models.Transaction{}, "amount_rub", "amount_fiat"); err != nil {
+			return nil, err
+		}
+		backfills = append(backfills, func(db *gorm.DB) error {
+			return db.Model(&models.Transaction{}).
+				Where("status = ?", models.TransactionStatusCredited).
+				Update("currency", "RUB").
+				Error
+		})
+	}
+	if m.HasColumn(&models.Quote{}, "amount_rub") {
+		if err := m.RenameColumn(&models.Quote{}, "amount_rub", "amount_fiat"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Курсы хранились только для RUB и были уникальны по времени получения
+	if m.HasIndex(&models.ExchangeRate{}, "idx_exchange_rates_fetched_at") {
+		if err := m.DropIndex(&models.ExchangeRate{}, "idx_exchange_rates_fetched_at"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Уникальный индекс по пользователю разрешал ему лишь одну заявку на вывод за всё время,
+	// теперь уникальна только незавершённая заявка
+	for _, index := range []string{"idx_user_pending", "idx_withdrawals_user_open"} {
+		if m.HasIndex(&models.Withdrawal{}, index) {
+			if err := m.DropIndex(&models.Withdrawal{}, index); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Время создания заявки хранилось строкой и не заполнялось; времени переходов по статусам не было.
+	// Восстанавливаем их по записям журнала, а время создания без записей — по времени перевода.
+	if m.HasTable(&models.Withdrawal{}) && !m.HasColumn(&models.Withdrawal{}, "completed_at") {
+		if err := withdrawalCreatedAtToTime(db); err != nil {
+			return nil, err
+		}
+		backfills = append(backfills, withdrawalTimestamps)
+	}
+
+	// Пополнения учитывались по транзакции целиком, теперь — по выходу (txid, vout).
+	// Старые записи получают vout = -1 и покрывают все выходы своей транзакции.
+	// Незачисленные старые записи удаляем: проверка найдёт их выходы заново.
+	if m.HasTable(&models.Transaction{}) && !m.HasColumn(&models.Transaction{}, "vout") {
+		for _, sql := range []string{
+			"ALTER TABLE transactions ADD COLUMN vout bigint NOT NULL DEFAULT -1",
+			"ALTER TABLE transactions DROP CONSTRAINT transactions_pkey",
+			"ALTER TABLE transactions ADD PRIMARY KEY (tx_id, vout)",
+			"ALTER TABLE transactions ALTER COLUMN vout DROP DEFAULT",
+		} {
+			if err := db.Exec(sql).Error; err != nil {
+				return nil, fmt.Errorf("failed to key transactions by outpoint: %w", err)
+			}
+		}
+		if m.HasColumn(&models.Transaction{}, "status") {
+			err := db.Where("vout = ? AND status IN ?", models.TransactionLegacyVout,
+				[]string{models.TransactionStatusPending, models.TransactionStatusConfirming}).
+				Delete(&models.Transaction{}).
+				Error
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Лимиты пользователя назначались без валюты — в той, что была у него на момент миграции
+	if m.HasTable(&models.WithdrawalLimitOverride{}) && !m.HasColumn(&models.WithdrawalLimitOverride{}, "currency") {
+		backfills = append(backfills, func(db *gorm.DB) error {
+			return db.Exec(`UPDATE withdrawal_limit_overrides o SET currency = u.currency
+				FROM users u WHERE u.telegram_id = o.user_id`).Error
+		})
+	}
+
+	// Балансы менялись без журнала: при его появлении записываем текущие балансы начальными остатками
+	if m.HasTable(&models.User{}) && !m.HasTable(&models.LedgerEntry{}) {
+		backfills = append(backfills, openingBalances)
+	}
+
+	// Суммы хранились в float: переводим их в целые копейки и сатоши
+	for _, c := range []struct {
+		model    interface{}
+		from, to string
+		scale    int64
+	}{
+		{&models.User{}, "balance", "balance", 100},
+		{&models.Withdrawal{}, "amount", "amount", 100},
+		{&models.Transaction{}, "amount_btc", "amount_sats", 1e8},
+		{&models.Transaction{}, "amount_fiat", "amount_fiat", 100},
+		{&models.Quote{}, "amount_btc", "amount_sats", 1e8},
+		{&models.Quote{}, "amount_fiat", "amount_fiat", 100},
+	} {
+		if err := toMinorUnits(db, c.model, c.from, c.to, c.scale); err != nil {
+			return nil, err
+		}
+	}
+
+	return backfills, nil
+}
+
+func openingBalances(db *gorm.DB) error {
+	var users []models.User
+	if err := db.Where("balance <> 0").Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		userID := user.TelegramID
+		entry := &models.LedgerEntry{
+			Kind:        models.LedgerEntryOpening,
+			Currency:    user.Currency,
+			Description: "начальный остаток",
+			Postings: []models.LedgerPosting{
+				{Account: models.LedgerAccountUser, UserID: &userID, Currency: user.Currency, Amount: user.Balance},
+				{Account: models.LedgerAccountOpening, Currency: user.Currency, Amount: -user.Balance},
+			},
+		}
+		if err := db.Create(entry).Error; err != nil {
+			return fmt.Errorf("failed to record opening balance of user %d: %w", userID, err)
+		}
+	}
+	return nil
+}
+
+func withdrawalCreatedAtToTime(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.Withdrawal{}, "created_at") {
+		return nil
+	}
+	columnTypes, err := db.Migrator().ColumnTypes(&models.Withdrawal{})
+	if err != nil {
+		return err
+	}
+	for _, ct := range columnTypes {
+		if ct.Name() != "created_at" {
+			continue
+		}
+		switch strings.ToLower(ct.DatabaseTypeName()) {
+		case "text", "varchar", "character varying":
+		default:
+			return nil
+		}
+	}
+
+	err = db.Exec(`ALTER TABLE withdrawals ALTER COLUMN created_at TYPE timestamptz USING
+		CASE WHEN created_at ~ '^\d{4}-\d{2}-\d{2}' THEN created_at::timestamptz END`).Error
+	if err != nil {
+		return fmt.Errorf("failed to convert withdrawals.created_at to timestamp: %w", err)
+	}
+	return nil
+}
+
+func withdrawalTimestamps(db *gorm.DB) error {
+	for _, sql := range []string{
+		`UPDATE withdrawals w SET created_at = e.first FROM (
+			SELECT withdrawal_id, MIN(created_at) AS first FROM ledger_entries
+			WHERE withdrawal_id IS NOT NULL GROUP BY withdrawal_id
+		) e WHERE e.withdrawal_id = w.id AND w.created_at IS NULL`,
+		`UPDATE withdrawals SET created_at = COALESCE(paid_at, now()) WHERE created_at IS NULL`,
+		`UPDATE withdrawals w SET completed_at = e.created_at FROM ledger_entries e
+			WHERE e.withdrawal_id = w.id AND e.kind = 'withdrawal' AND w.status = 'completed'`,
+		`UPDATE withdrawals w SET canceled_at = e.created_at FROM ledger_entries e
+			WHERE e.withdrawal_id = w.id AND e.key = 'release:' || w.id AND w.status = 'canceled'`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			return fmt.Errorf("failed to backfill withdrawal timestamps: %w", err)
+		}
+	}
+	return nil
+}
+
+// toMinorUnits переводит колонку с дробной суммой в bigint, умножая значения на scale
+// с округлением к ближайшему, и при необходимости переименовывает её.
+func toMinorUnits(db *gorm.DB, model interface{}, from, to string, scale int64) error {
+	m := db.Migrator()
+	if !m.HasColumn(model, from) {
+		return nil
+	}
+
+	columnTypes, err := m.ColumnTypes(model)
+	if err != nil {
+		return err
+	}
+	isFloat := false
+	for _, ct := range columnTypes {
+		if ct.Name() != from {
+			continue
+		}
+		switch strings.ToLower(ct.DatabaseTypeName()) {
+		case "float4", "float8", "real", "double precision", "numeric", "decimal":
+			isFloat = true
+		}
+	}
+
+	if isFloat {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		err := db.Exec(
+			fmt.Sprintf("ALTER TABLE ? ALTER COLUMN ? DROP DEFAULT, ALTER COLUMN ? TYPE bigint USING round(? * %d)::bigint", scale),
+			clause.Table{Name: stmt.Schema.Table}, clause.Column{Name: from}, clause.Column{Name: from}, clause.Column{Name: from},
+		).Error
+		if err != nil {
+			return fmt.Errorf("failed to convert %s.%s to minor units: %w", stmt.Schema.Table, from, err)
+		}
+	}
+
+	if from != to {
+		return m.RenameColumn(model, from, to)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// escapeMarkdown экранирует разметку в тексте, введённом пользователем.
+func escapeMarkdown(text string) string {
+	return strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[").Replace(text)
+}
+
+func signedAmount(amount money.Amount) string {
+	if amount > 0 {
+		return "+" + amount.String()
+	}
+	return amount.String()
+}
+
+// pendingAdjustment — корректировка, показанная администратору. Кнопки несут её id,
+// поэтому кнопки старого запроса не применят более новую корректировку.
+type pendingAdjustment struct {
+	id         string
+	adjustment *models.BalanceAdjustment
+}
+
+// takeAdjustment забирает корректировку администратора, если кнопка относится к последнему запросу.
+func (b *Bot) takeAdjustment(adminID int64, id string) *models.BalanceAdjustment {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+
+	pending := b.pendingAdjustments[adminID]
+	if pending == nil || pending.id != id {
+		return nil
+	}
+	delete(b.pendingAdjustments, adminID)
+	return pending.adjustment
+}
+
+// handleAdjustCommand готовит ручную корректировку баланса и просит администратора её подтвердить.
+func (b *Bot) handleAdjustCommand(ctx context.Context, chatID, adminID int64, args []string) {
+	const usage = "Использование: `/adjust <telegram_id> <+/-сумма> <причина>`"
+	if len(args) < 3 {
+		b.sendMessage(chatID, usage, nil)
+		return
+	}
+
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Неверный telegram ID.", nil)
+		return
+	}
+
+	// Знак обязателен, чтобы нельзя было случайно перепутать зачисление и списание
+	if !strings.HasPrefix(args[1], "+") && !strings.HasPrefix(args[1], "-") {
+		b.sendMessage(chatID, "❌ Укажите знак суммы: `+` — зачислить, `-` — списать.", nil)
+		return
+	}
+	amount, err := money.Parse(args[1])
+	if err != nil || amount == 0 {
+		b.sendMessage(chatID, "❌ Неверная сумма. Пример: `+1500` или `-250.50`", nil)
+		return
+	}
+
+	user, err := b.service.GetUser(ctx, userID)
+	if err != nil {
+		b.logger.Errorf("Failed to get user %d for adjustment: %v", userID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить пользователя.", nil)
+		return
+	}
+	if user == nil {
+		b.sendMessage(chatID, fmt.Sprintf("❌ Пользователь `%d` не найден.", userID), nil)
+		return
+	}
+
+	adjustment := &models.BalanceAdjustment{
+		UserID:        userID,
+		AdminID:       adminID,
+		Amount:        amount,
+		Currency:      user.Currency,
+		Reason:        strings.Join(args[2:], " "),
+		BalanceBefore: user.Balance,
+		BalanceAfter:  user.Balance + amount,
+	}
+
+	id, err := newCallbackID()
+	if err != nil {
+		b.logger.Errorf("Failed to generate adjustment id: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось подготовить корректировку.", nil)
+		return
+	}
+	b.stateMutex.Lock()
+	b.pendingAdjustments[adminID] = &pendingAdjustment{id: id, adjustment: adjustment}
+	b.stateMutex.Unlock()
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Применить", "adjust:confirm:"+id),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Отменить", "adjust:cancel:"+id),
+	))
+	b.sendMessage(chatID, fmt.Sprintf(
+		"⚠️ Корректировка баланса пользователя `%d`\n\n"+
+			"Сумма: `%s` %s\n"+
+			"Баланс сейчас: `%s` → `%s` %s\n"+
+			"Причина: %s\n\n"+
+			"Итоговый баланс пересчитается в момент применения. Применить?",
+		userID, signedAmount(amount), adjustment.Currency,
+		adjustment.BalanceBefore, adjustment.BalanceAfter, adjustment.Currency,
+		escapeMarkdown(adjustment.Reason),
+	), keyboard)
+}
+
+// handleAdjustCallback применяет или отменяет корректировку, ожидающую подтверждения.
+// Корректировка забирается из очереди до применения, поэтому повторное нажатие или кнопка
+// старого запроса ничего не сделают.
+func (b *Bot) handleAdjustCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	adminID := callback.From.ID
+	chatID := callback.Message.Chat.ID
+
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 {
+		b.answerCallback(callback.ID, "Неизвестное действие.")
+		return
+	}
+	action, id := parts[1], parts[2]
+	pending := b.takeAdjustment(adminID, id)
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	if _, err := b.API.Send(edit); err != nil {
+		b.logger.Errorf("Failed to remove adjustment keyboard: %v", err)
+	}
+
+	if pending == nil {
+		b.answerCallback(callback.ID, "Корректировка уже обработана или заменена более новой.")
+		return
+	}
+	if action != "confirm" {
+		b.answerCallback(callback.ID, "Корректировка отменена.")
+		b.sendMessage(chatID, "❌ Корректировка отменена.", nil)
+		return
+	}
+	b.answerCallback(callback.ID, "")
+
+	adjustment, err := b.service.AdjustBalance(ctx, adminID, pending.UserID, pending.Amount, pending.Currency, pending.Reason)
+	if errors.Is(err, models.ErrInsufficientFunds) {
+		b.sendMessage(chatID, "❌ Списание больше текущего баланса пользователя, корректировка не применена.", nil)
+		return
+	}
+	if err != nil {
+		b.logger.Errorf("Failed to adjust balance of user %d: %v", pending.UserID, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось применить корректировку: %v", err), nil)
+		return
+	}
+
+	b.sendMessage(chatID, fmt.Sprintf(
+		"✅ Баланс пользователя `%d` скорректирован на `%s` %s: `%s` → `%s` %s",
+		adjustment.UserID, signedAmount(adjustment.Amount), adjustment.Currency,
+		adjustment.BalanceBefore, adjustment.BalanceAfter, adjustment.Currency,
+	), nil)
+
+	userText := strings.NewReplacer(
+		"{amount}", signedAmount(adjustment.Amount),
+		"{currency}", adjustment.Currency,
+		"{reason}", escapeMarkdown(adjustment.Reason),
+		"{balance}", adjustment.BalanceAfter.String(),
+	).Replace(b.config.AdjustmentMessage)
+	b.sendMessage(adjustment.UserID, userText, nil)
+}
@@ -0,0 +1,203 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleAdminCommand обрабатывает команды администратора.
+// Возвращает false, если команда не относится к администрированию.
+func (b *Bot) handleAdminCommand(ctx context.Context, msg *tgbotapi.Message) bool {
+	chatID := msg.Chat.ID
+	args := strings.Fields(msg.CommandArguments())
+
+	switch msg.Command() {
+	case "held":
+		b.handleHeldDeposits(ctx, chatID)
+	case "rescan":
+		b.handleRescan(ctx, chatID, args)
+	case "ratehistory":
+		b.handleRateHistory(ctx, chatID, args)
+	case "vip":
+		b.handleSetVIP(ctx, chatID, args)
+	case "rebuildbalances":
+		b.handleRebuildBalances(ctx, chatID, args)
+	case "withdrawals":
+		b.handleWithdrawals(ctx, chatID)
+	case "withdrawal":
+		b.handleAdminWithdrawal(ctx, chatID, args)
+	case "adjust":
+		b.handleAdjustCommand(ctx, chatID, msg.From.ID, args)
+	case "disputes":
+		b.handleOpenDisputes(ctx, chatID)
+	case "dispute":
+		// Без номера спора администратор открывает собственный спор как обычный пользователь
+		if len(args) == 0 {
+			return false
+		}
+		b.handleAdminDispute(ctx, chatID, args[0])
+	case "resolve":
+		b.handleResolveCommand(ctx, chatID, msg.From.ID, args)
+	case "limits":
+		// Без telegram_id администратор смотрит собственные лимиты как обычный пользователь
+		userID, ok := parseLimitsTarget(args)
+		if !ok {
+			return false
+		}
+		b.handleAdminLimits(ctx, chatID, msg.From.ID, userID, args[1:])
+	case "statement":
+		// Без telegram_id администратор запрашивает собственную выписку как обычный пользователь
+		if len(args) == 0 {
+			return false
+		}
+		userID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		b.handleAdminStatement(ctx, chatID, userID, args[1:])
+	case "reconcile":
+		b.sendMessage(chatID, "🧮 Сверяю пополнения, это может занять время...", nil)
+		b.handleReconcile(ctx, chatID)
+	default:
+		return false
+	}
+	return true
+}
+
+func (b *Bot) handleRescan(ctx context.Context, chatID int64, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		b.sendMessage(chatID, "Использование: `/rescan <telegram_id|адрес> [с_блока]`", nil)
+		return
+	}
+
+	var fromHeight int64
+	if len(args) == 2 {
+		height, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || height < 0 {
+			b.sendMessage(chatID, "❌ Неверная высота блока.", nil)
+			return
+		}
+		fromHeight = height
+	}
+
+	b.sendMessage(chatID, "🔎 Сканирую историю адреса, это может занять время...", nil)
+
+	report, err := b.service.Rescan(ctx, args[0], fromHeight, b.notifyAboutTransaction)
+	if err != nil {
+		b.logger.Errorf("Rescan of %s failed: %v", args[0], err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось выполнить пересканирование: %v", err), nil)
+		if report == nil || len(report.Deposits) == 0 {
+			return
+		}
+	}
+
+	var sb strings.Builder
+	if err != nil {
+		sb.WriteString("⚠️ Пересканирование прервано, ниже — что успели найти и сверить.\n\n")
+	}
+	sb.WriteString(fmt.Sprintf(
+		"🔎 Пересканирование адреса `%s`\n👤 Пользователь: `%d`\n🧱 С блока: `%d`\n\nНайдено поступлений: %d\n",
+		report.Address, report.UserID, report.FromHeight, len(report.Deposits),
+	))
+
+	for _, dep := range report.Deposits {
+		height := "в мемпуле"
+		if dep.BlockHeight > 0 {
+			height = fmt.Sprintf("блок %d", dep.BlockHeight)
+		}
+		sb.WriteString(fmt.Sprintf("\n`%.8f` BTC, %s\n`%s:%d`\n%s → %s\n",
+			dep.AmountSats.ToBTC(), height, dep.TxID, dep.Vout,
+			b.rescanStatusText(dep.PreviousStatus), b.rescanStatusText(dep.Status)))
+	}
+
+	if report.Credited > 0 {
+		sb.WriteString(fmt.Sprintf("\n✅ Зачислено пропущенных пополнений на `%s` %s", report.Credited, report.Currency))
+	} else {
+		sb.WriteString("\nПропущенных пополнений не найдено.")
+	}
+
+	b.sendLongMessage(chatID, sb.String(), nil)
+}
+
+func (b *Bot) rescanStatusText(status string) string {
+	switch status {
+	case "":
+		return "❔ не найдено"
+	case models.TransactionStatusConfirming:
+		return "🔄 подтверждается"
+	default:
+		return b.depositStatusText(&models.Transaction{Status: status})
+	}
+}
+
+func (b *Bot) handleSetVIP(ctx context.Context, chatID int64, args []string) {
+	if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+		b.sendMessage(chatID, "Использование: `/vip <telegram_id> on|off`", nil)
+		return
+	}
+
+	telegramID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Неверный telegram ID.", nil)
+		return
+	}
+
+	vip := args[1] == "on"
+	if err := b.service.SetUserVIP(ctx, telegramID, vip); err != nil {
+		b.logger.Errorf("Failed to set VIP flag for user %d: %v", telegramID, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось изменить VIP-статус: %v", err), nil)
+		return
+	}
+
+	if vip {
+		b.sendMessage(chatID, fmt.Sprintf("✅ Пользователь `%d` получил VIP-статус (спред `%.2f%%`).", telegramID, b.config.VIPSpreadPercent), nil)
+	} else {
+		b.sendMessage(chatID, fmt.Sprintf("✅ VIP-статус пользователя `%d` снят.", telegramID), nil)
+	}
+}
+
+// handleRebuildBalances сверяет балансы с журналом. По умолчанию (и с аргументом check) только показывает
+// расхождения; перезаписывает балансы остатками по журналу лишь явный аргумент apply.
+func (b *Bot) handleRebuildBalances(ctx context.Context, chatID int64, args []string) {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "check" && args[0] != "apply") {
+		b.sendMessage(chatID, "Использование: `/rebuildbalances [check|apply]` — по умолчанию только сверка, "+
+			"`apply` перезаписывает балансы остатками по журналу", nil)
+		return
+	}
+	fix := len(args) == 1 && args[0] == "apply"
+
+	drifts, err := b.service.RebuildBalances(ctx, fix)
+	if err != nil {
+		b.logger.Errorf("Failed to rebuild balances: %v", err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось пересчитать балансы: %v", err), nil)
+	}
+	if err == nil && len(drifts) == 0 {
+		b.sendMessage(chatID, "✅ Все балансы совпадают с журналом.", nil)
+		return
+	}
+	if len(drifts) == 0 {
+		return
+	}
+
+	var sb strings.Builder
+	if fix {
+		sb.WriteString("🛠 Балансы пересчитаны по журналу. Исправлены расхождения:\n")
+	} else {
+		sb.WriteString("⚠️ Расхождения балансов с журналом:\n")
+	}
+	for _, drift := range drifts {
+		sb.WriteString(fmt.Sprintf(
+			"\n👤 `%d`: было `%s`, по журналу `%s` %s (разница `%s`)",
+			drift.UserID, drift.Stored, drift.Ledger, drift.Currency, drift.Ledger-drift.Stored,
+		))
+	}
+	if !fix {
+		sb.WriteString("\n\nБалансы не изменены. Чтобы перезаписать их остатками по журналу: `/rebuildbalances apply`")
+	}
+	b.sendLongMessage(chatID, sb.String(), nil)
+}
@@ -2,11 +2,15 @@ package bot
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/Fi44er/btc_bot/config"
 	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/internal/service"
 	"github.com/Fi44er/btc_bot/utils"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -16,23 +20,67 @@ type IService interface {
 	GetUsersWithWallets(ctx context.Context) ([]*models.User, error)
 	CreateUser(ctx context.Context, userID int64) error
 	UpdateCardNumber(ctx context.Context, userID int64, cardNumber string) error
-	HandleCheckTransactions(ctx context.Context, userID int64, notifyCallback models.NotifyCallback) (float64, error)
+	SetUserVIP(ctx context.Context, telegramID int64, vip bool) error
+	SetUserCurrency(ctx context.Context, telegramID int64, currency string) error
+	GetSupportedCurrencies() []string
+	HandleCheckTransactions(ctx context.Context, userID int64, notifyCallback models.NotifyCallback) (money.Amount, error)
+	GetUserDeposits(ctx context.Context, userID int64, limit int) ([]models.Transaction, error)
+	GetHeldDeposits(ctx context.Context) ([]models.Transaction, error)
+	SnapshotRate(ctx context.Context) error
+	GetRateSummary(ctx context.Context, currency string) (*models.RateSummary, error)
+	GetRateHistory(ctx context.Context, currency string, from, to time.Time) ([]models.ExchangeRate, error)
+
+	Rescan(ctx context.Context, target string, fromHeight int64, notifyCallback models.NotifyCallback) (*models.RescanReport, error)
 	GetAdminChatID() int64
 
 	UpdateUserWallet(ctx context.Context, telegramID int64) (*models.User, error)
-	GetBTCRUBRate() (float64, error)
-
-	UpdateUserBalance(ctx context.Context, userID int64, newBalance float64) error
+	CreateQuote(ctx context.Context, telegramID int64, amount money.Amount) (*models.Quote, error)
+
+	PreviewWithdrawal(ctx context.Context, userID int64, method string, amount money.Amount) (*models.Withdrawal, error)
+	CreateOrUpdateWithdrawal(ctx context.Context, withdrawalDelta *models.Withdrawal) (*models.Withdrawal, bool, error)
+	GetPendingWithdrawalByUserID(ctx context.Context, userID int64) (*models.Withdrawal, error)
+	GetPendingWithdrawals(ctx context.Context) ([]*models.Withdrawal, error)
+	GetWithdrawalByID(ctx context.Context, id int64) (*models.Withdrawal, error)
+	GetWithdrawalTransitions(ctx context.Context, withdrawalID uint) ([]models.WithdrawalTransition, error)
+	UpdateWithdrawalStatus(ctx context.Context, adminID, id int64, status string) error
+	VoidPayout(ctx context.Context, adminID, withdrawalID int64) error
+	RecordPayout(ctx context.Context, adminID, withdrawalID int64, amount money.Amount, reference string, paidAt time.Time) (*models.Withdrawal, error)
+	ConfirmPayout(ctx context.Context, userID, withdrawalID int64) error
+	DisputePayout(ctx context.Context, userID, withdrawalID int64) error
+	GetReservedBalance(ctx context.Context, userID int64) (money.Amount, error)
+	AdjustBalance(ctx context.Context, adminID, userID int64, amount money.Amount, currency, reason string) (*models.BalanceAdjustment, error)
+	RebuildBalances(ctx context.Context, fix bool) ([]models.BalanceDrift, error)
+	Reconcile(ctx context.Context) (*models.ReconcileReport, error)
+	GetHistory(ctx context.Context, userID int64, page int) (*models.HistoryPage, error)
+	GetStatement(ctx context.Context, userID int64, from, to time.Time) (*models.Statement, error)
+
+	OpenDispute(ctx context.Context, dispute *models.Dispute) (*models.Dispute, error)
+	GetDispute(ctx context.Context, id int64) (*models.Dispute, error)
+	GetOpenDisputes(ctx context.Context) ([]models.Dispute, error)
+	GetUserDisputes(ctx context.Context, userID int64, limit int) ([]models.Dispute, error)
+	GetUserWithdrawals(ctx context.Context, userID int64, limit int) ([]models.Withdrawal, error)
+	GetDisputeMessages(ctx context.Context, disputeID uint) ([]models.DisputeMessage, error)
+	AddDisputeMessage(ctx context.Context, disputeID int64, senderID int64, fromAdmin bool, text string) (*models.Dispute, error)
+	ResolveDispute(ctx context.Context, adminID, disputeID int64, status string, amount money.Amount, comment string) (*models.Dispute, error)
+
+	GetWithdrawalLimits(ctx context.Context, userID int64) (*models.WithdrawalLimits, error)
+	SetWithdrawalLimitOverride(ctx context.Context, adminID int64, override *models.WithdrawalLimitOverride) error
+	ResetWithdrawalLimitOverride(ctx context.Context, adminID, userID int64) error
 }
 
 type Bot struct {
-	API            *tgbotapi.BotAPI
-	service        IService
-	logger         *utils.Logger
-	config         *config.Config
-	stateMutex     *sync.Mutex
-	userStates     map[int64]string
-	userActionData map[int64]string
+	API              *tgbotapi.BotAPI
+	service          IService
+	logger           *utils.Logger
+	config           *config.Config
+	stateMutex       *sync.Mutex
+	userStates       map[int64]string
+	userActionData   map[int64]string
+	lastDepositCheck map[int64]time.Time
+	// Корректировки баланса, ожидающие подтверждения администратором
+	pendingAdjustments map[int64]*pendingAdjustment
+	// Показанные пользователям расчёты вывода, ожидающие подтверждения
+	pendingWithdrawals map[int64]*withdrawPreview
 }
 
 func NewBot(
@@ -42,13 +90,17 @@ func NewBot(
 	config *config.Config,
 ) *Bot {
 	return &Bot{
-		API:            api,
-		service:        service,
-		logger:         logger,
-		config:         config,
-		stateMutex:     &sync.Mutex{},
-		userStates:     make(map[int64]string),
-		userActionData: make(map[int64]string),
+		API:              api,
+		service:          service,
+		logger:           logger,
+		config:           config,
+		stateMutex:       &sync.Mutex{},
+		userStates:       make(map[int64]string),
+		userActionData:   make(map[int64]string),
+		lastDepositCheck: make(map[int64]time.Time),
+
+		pendingAdjustments: make(map[int64]*pendingAdjustment),
+		pendingWithdrawals: make(map[int64]*withdrawPreview),
 	}
 }
 
@@ -56,6 +108,8 @@ func (b *Bot) Start() {
 	b.logger.Info("Starting bot...")
 
 	go b.startTransactionChecker()
+	go b.startRateSnapshotter()
+	go b.startReconciler()
 
 	updates := b.API.GetUpdatesChan(tgbotapi.NewUpdate(0))
 	for update := range updates {
@@ -89,8 +143,19 @@ func GetMainMenu(user *models.User) tgbotapi.ReplyKeyboardMarkup {
 		},
 		{
 			tgbotapi.NewKeyboardButton("💰 Получить адрес для пополнения"),
-			// Новая кнопка для подтверждения вывода
-			tgbotapi.NewKeyboardButton("✅ Пришло на карту"),
+			tgbotapi.NewKeyboardButton("💸 Вывести средства"),
+		},
+		{
+			tgbotapi.NewKeyboardButton("🔄 Проверить пополнение"),
+			tgbotapi.NewKeyboardButton("🔒 Зафиксировать курс"),
+		},
+		{
+			tgbotapi.NewKeyboardButton("📜 История"),
+			tgbotapi.NewKeyboardButton("💱 Сменить валюту"),
+		},
+		{
+			tgbotapi.NewKeyboardButton("🧾 Выписка"),
+			tgbotapi.NewKeyboardButton("⚖️ Спор"),
 		},
 	}
 
@@ -119,12 +184,23 @@ func (b *Bot) startTransactionChecker() {
 		}
 
 		b.logger.Infof("Checking transactions for %d users...", len(users))
+		var deferred []int64
 		for _, user := range users {
 			_, err := b.service.HandleCheckTransactions(ctx, user.TelegramID, b.notifyAboutTransaction)
-			if err != nil {
+			if errors.Is(err, service.ErrRateUnavailable) {
+				deferred = append(deferred, user.TelegramID)
+			} else if err != nil {
 				b.logger.Warnf("Error checking transaction for user %d: %v", user.TelegramID, err)
 			}
 			time.Sleep(1 * time.Second)
 		}
+
+		if len(deferred) > 0 {
+			b.sendMessage(b.service.GetAdminChatID(), fmt.Sprintf(
+				"⚠️ Курс BTC недоступен, а сохранённый старше %s.\n"+
+					"Зачисление подтверждённых пополнений отложено для %d пользователей: `%v`",
+				b.config.RateMaxAge, len(deferred), deferred,
+			), nil)
+		}
 	}
 }
@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (b *Bot) handleCurrencyRequest(_ context.Context, chatID int64, user *models.User) {
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, currency := range b.service.GetSupportedCurrencies() {
+		text := currency
+		if currency == user.Currency {
+			text = "✅ " + currency
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(text, "currency:"+currency))
+	}
+
+	msgText := fmt.Sprintf(
+		"Текущая валюта баланса: %s\n\nВыберите новую валюту. Сменить её можно только при нулевом балансе.",
+		user.Currency,
+	)
+	b.sendMessage(chatID, msgText, tgbotapi.NewInlineKeyboardMarkup(buttons))
+}
+
+func (b *Bot) handleCurrencyCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	userID := callback.From.ID
+	currency := strings.TrimPrefix(callback.Data, "currency:")
+
+	if err := b.service.SetUserCurrency(ctx, userID, currency); err != nil {
+		b.logger.Warnf("Failed to set currency %s for user %d: %v", currency, userID, err)
+		b.answerCallback(callback.ID, "❌ "+err.Error())
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(callback.Message.Chat.ID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{})
+	b.API.Send(edit)
+
+	user, err := b.service.GetUser(ctx, userID)
+	if err != nil {
+		b.logger.Errorf("Failed to get user %d after currency change: %v", userID, err)
+		b.answerCallback(callback.ID, "")
+		return
+	}
+
+	b.sendMessage(userID, fmt.Sprintf("✅ Валюта баланса: %s", currency), GetMainMenu(user))
+	b.answerCallback(callback.ID, "")
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/service"
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+const depositCheckListLimit = 5
+
+func (b *Bot) handleDepositCheck(ctx context.Context, chatID int64, user *models.User) {
+	if user.SystemWallet == nil || user.SystemWallet.Address == "" {
+		b.sendMessage(chatID, "❌ У вас ещё нет адреса для пополнения. Сначала получите его в меню.", GetMainMenu(user))
+		return
+	}
+
+	if ok, wait := b.allowDepositCheck(user.TelegramID); !ok {
+		msg := fmt.Sprintf("⏳ Проверять пополнение можно не чаще раза в %s. Попробуйте через %d сек.",
+			b.config.ManualCheckInterval, int(math.Ceil(wait.Seconds())))
+		b.sendMessage(chatID, msg, GetMainMenu(user))
+		return
+	}
+
+	b.sendMessage(chatID, "🔄 Проверяю поступления на ваш адрес...", nil)
+
+	credited, err := b.service.HandleCheckTransactions(ctx, user.TelegramID, b.notifyAboutTransaction)
+	if errors.Is(err, service.ErrRateUnavailable) {
+		b.logger.Warnf("Credit deferred for user %d: %v", user.TelegramID, err)
+	} else if err != nil {
+		b.logger.Errorf("Manual deposit check failed for user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось проверить пополнение. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+
+	deposits, err := b.service.GetUserDeposits(ctx, user.TelegramID, depositCheckListLimit)
+	if err != nil {
+		b.logger.Errorf("Failed to get deposits for user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить список пополнений. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+
+	var sb strings.Builder
+	if len(deposits) == 0 {
+		sb.WriteString("Поступлений на ваш адрес пока нет.")
+	} else {
+		sb.WriteString("Последние поступления:\n")
+		for _, tx := range deposits {
+			sb.WriteString(fmt.Sprintf("\n`%.8f` BTC — %s", tx.AmountSats.ToBTC(), b.depositStatusText(&tx)))
+			if tx.Status == models.TransactionStatusCredited && tx.AmountFiat > 0 {
+				sb.WriteString(fmt.Sprintf(" (`%s` %s по курсу `%.2f`)", tx.AmountFiat, tx.Currency, tx.Rate))
+			}
+			sb.WriteString(fmt.Sprintf("\n`%s`\n", tx.TxID))
+		}
+	}
+
+	if credited > 0 {
+		sb.WriteString(fmt.Sprintf("\n✅ Сейчас зачислено: `%s` %s", credited, user.Currency))
+	}
+
+	b.sendMessage(chatID, sb.String(), GetMainMenu(user))
+}
+
+func (b *Bot) depositStatusText(tx *models.Transaction) string {
+	switch tx.Status {
+	case models.TransactionStatusCredited:
+		return "✅ зачислено"
+	case models.TransactionStatusHeld:
+		return "🟡 меньше минимальной суммы, ждёт накопления"
+	case models.TransactionStatusIgnored:
+		return "⚪️ слишком малая сумма, не зачисляется"
+	case models.TransactionStatusConfirming:
+		if tx.Confirmations >= b.config.MinConfirmations {
+			return "⏸ подтверждено, зачисление отложено"
+		}
+		return fmt.Sprintf("🔄 подтверждается (%d/%d)", tx.Confirmations, b.config.MinConfirmations)
+	default:
+		return "⏳ ожидает подтверждения в сети"
+	}
+}
+
+func (b *Bot) handleHeldDeposits(ctx context.Context, chatID int64) {
+	deposits, err := b.service.GetHeldDeposits(ctx)
+	if err != nil {
+		b.logger.Errorf("Failed to get held deposits: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось получить отложенные пополнения.", nil)
+		return
+	}
+
+	if len(deposits) == 0 {
+		b.sendMessage(chatID, "Отложенных и проигнорированных пополнений нет.", nil)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Минимум к зачислению: `%d` сат., порог пыли: `%d` сат.\n",
+		b.config.MinDepositSats, b.config.DustLimitSats))
+
+	var userID int64
+	var held btcutil.Amount
+	flushUser := func() {
+		if userID != 0 && held > 0 {
+			sb.WriteString(fmt.Sprintf("Накоплено: `%.8f` BTC\n", held.ToBTC()))
+		}
+	}
+	for _, tx := range deposits {
+		if tx.UserID != userID {
+			flushUser()
+			userID, held = tx.UserID, 0
+			sb.WriteString(fmt.Sprintf("\n👤 `%d`\n", userID))
+		}
+		if tx.Status == models.TransactionStatusHeld {
+			held += tx.AmountSats
+		}
+		sb.WriteString(fmt.Sprintf("`%.8f` BTC — %s\n`%s`\n", tx.AmountSats.ToBTC(), b.depositStatusText(&tx), tx.TxID))
+	}
+	flushUser()
+
+	b.sendLongMessage(chatID, sb.String(), nil)
+}
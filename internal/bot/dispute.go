@@ -0,0 +1,397 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/internal/service"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Сколько последних пополнений и выводов предлагается оспорить
+const disputeCandidatesLimit = 5
+
+// handleDisputeRequest показывает споры пользователя и предлагает выбрать пополнение или вывод,
+// по которому открыть новый. Выбранные варианты хранятся в данных действия вместе с id списка: в кнопку
+// не помещается txid, поэтому кнопка несёт id списка и номер варианта, а кнопки старых списков отклоняются.
+func (b *Bot) handleDisputeRequest(ctx context.Context, chatID int64, user *models.User) {
+	userID := user.TelegramID
+
+	disputes, err := b.service.GetUserDisputes(ctx, userID, disputeCandidatesLimit)
+	if err != nil {
+		b.logger.Errorf("Failed to get disputes of user %d: %v", userID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить ваши споры. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	deposits, err := b.service.GetUserDeposits(ctx, userID, disputeCandidatesLimit)
+	if err != nil {
+		b.logger.Errorf("Failed to get deposits of user %d: %v", userID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить ваши операции. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	withdrawals, err := b.service.GetUserWithdrawals(ctx, userID, disputeCandidatesLimit)
+	if err != nil {
+		b.logger.Errorf("Failed to get withdrawals of user %d: %v", userID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить ваши операции. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+
+	var text strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(disputes) > 0 {
+		text.WriteString("⚖️ *Ваши споры:*\n")
+		for _, dispute := range disputes {
+			text.WriteString(fmt.Sprintf("#%d — %s: %s\n", dispute.ID, dispute.Subject(), disputeStatusText(dispute.Status)))
+			if dispute.Status == models.DisputeStatusOpen {
+				rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf("✉️ Написать по спору #%d", dispute.ID), fmt.Sprintf("dispute:msg:%d", dispute.ID))))
+			}
+		}
+		text.WriteString("\n")
+	}
+
+	listID, err := newCallbackID()
+	if err != nil {
+		b.logger.Errorf("Failed to generate dispute list ID: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось получить ваши операции. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	var candidates []string
+	for _, deposit := range deposits {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("📥 %.8f BTC · %s", deposit.AmountSats.ToBTC(), deposit.CreatedAt.Local().Format("02.01 15:04")),
+			fmt.Sprintf("dispute:open:%s:%d", listID, len(candidates)))))
+		candidates = append(candidates, fmt.Sprintf("d:%s:%d", deposit.TxID, deposit.Vout))
+	}
+	for _, withdrawal := range withdrawals {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("💸 Вывод #%d · %s %s", withdrawal.ID, withdrawal.Amount, withdrawal.Currency),
+			fmt.Sprintf("dispute:open:%s:%d", listID, len(candidates)))))
+		candidates = append(candidates, fmt.Sprintf("w:%d", withdrawal.ID))
+	}
+
+	if len(rows) == 0 {
+		b.sendMessage(chatID, "У вас пока нет пополнений и выводов, которые можно оспорить.", GetMainMenu(user))
+		return
+	}
+	if len(candidates) > 0 {
+		text.WriteString("Выберите пополнение или вывод, с которым что-то не так. " +
+			"Пока спор открыт, операция заморожена: пополнение не зачисляется, а заявка на вывод не двигается.")
+	}
+
+	b.setUserActionData(userID, strings.Join(append([]string{listID}, candidates...), "|"))
+	b.sendMessage(chatID, text.String(), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// handleDisputeCallback обрабатывает выбор операции для спора и кнопки переписки по спору.
+func (b *Bot) handleDisputeCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "dispute:"), ":")
+	userID := callback.From.ID
+	chatID := callback.Message.Chat.ID
+
+	switch {
+	case len(parts) == 3 && parts[0] == "open":
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
+			return
+		}
+		// Первый элемент — id списка: кнопки списка, показанного раньше, открыли бы спор не по той операции
+		list := strings.Split(b.getUserActionData(userID), "|")
+		if list[0] != parts[1] || n < 0 || n+1 >= len(list) || list[n+1] == "" {
+			b.answerCallback(callback.ID, "Список устарел, откройте его заново.")
+			return
+		}
+		b.setUserActionData(userID, list[n+1])
+		b.setState(userID, stateAwaitingDisputeReason)
+		b.answerCallback(callback.ID, "")
+		b.sendMessage(chatID, "Опишите одним сообщением, что не так с операцией:", tgbotapi.NewRemoveKeyboard(true))
+	case len(parts) == 2 && parts[0] == "msg":
+		disputeID, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
+			return
+		}
+		b.setUserActionData(userID, parts[1])
+		b.setState(userID, stateAwaitingDisputeMessage)
+		b.answerCallback(callback.ID, "")
+		b.sendMessage(chatID, fmt.Sprintf("Напишите сообщение по спору #%d одним сообщением:", disputeID), tgbotapi.NewRemoveKeyboard(true))
+	default:
+		b.answerCallback(callback.ID, "Ошибка: неизвестное действие.")
+	}
+}
+
+// parseDisputeSubject разбирает выбранную операцию вида d:txid:vout или w:id.
+func parseDisputeSubject(subject string) (*models.Dispute, error) {
+	parts := strings.Split(subject, ":")
+	switch {
+	case len(parts) == 3 && parts[0] == "d":
+		vout, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Dispute{TransactionID: &parts[1], Vout: &vout}, nil
+	case len(parts) == 2 && parts[0] == "w":
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		withdrawalID := uint(id)
+		return &models.Dispute{WithdrawalID: &withdrawalID}, nil
+	default:
+		return nil, fmt.Errorf("invalid dispute subject %q", subject)
+	}
+}
+
+// handleDisputeReasonInput открывает спор по выбранной операции и сообщает о нём администратору.
+func (b *Bot) handleDisputeReasonInput(ctx context.Context, chatID int64, user *models.User, text string) {
+	userID := user.TelegramID
+	subject := b.getUserActionData(userID)
+	b.clearUserActionData(userID)
+	b.setState(userID, stateDefault)
+
+	dispute, err := parseDisputeSubject(subject)
+	if err != nil {
+		b.logger.Errorf("Failed to parse dispute subject from action data: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось открыть спор. Выберите операцию заново.", GetMainMenu(user))
+		return
+	}
+	dispute.UserID = userID
+	dispute.Reason = text
+
+	dispute, err = b.service.OpenDispute(ctx, dispute)
+	if errors.Is(err, service.ErrDisputeOpen) {
+		b.sendMessage(chatID, fmt.Sprintf("❌ %v.", err), GetMainMenu(user))
+		return
+	}
+	if err != nil {
+		b.logger.Errorf("Failed to open dispute for user %d: %v", userID, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось открыть спор: %v", err), GetMainMenu(user))
+		return
+	}
+
+	b.sendMessage(chatID, fmt.Sprintf(
+		"⚖️ Спор #%d по операции «%s» открыт. Администратор рассмотрит его и ответит здесь. "+
+			"До решения операция заморожена.", dispute.ID, dispute.Subject(),
+	), GetMainMenu(user))
+	b.sendDisputeCard(ctx, b.service.GetAdminChatID(), dispute)
+}
+
+// handleDisputeMessageInput сохраняет сообщение в переписке по спору и пересылает его другой стороне.
+func (b *Bot) handleDisputeMessageInput(ctx context.Context, chatID int64, user *models.User, text string) {
+	userID := user.TelegramID
+	disputeID, err := strconv.ParseInt(b.getUserActionData(userID), 10, 64)
+	b.clearUserActionData(userID)
+	b.setState(userID, stateDefault)
+	if err != nil {
+		b.logger.Errorf("Failed to parse dispute ID from action data: %v", err)
+		return
+	}
+
+	fromAdmin := b.isAdmin(userID)
+	dispute, err := b.service.AddDisputeMessage(ctx, disputeID, userID, fromAdmin, text)
+	if err != nil {
+		b.logger.Warnf("Failed to add message to dispute #%d from %d: %v", disputeID, userID, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Сообщение не отправлено: %v", err), GetMainMenu(user))
+		return
+	}
+
+	reply := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✉️ Ответить", fmt.Sprintf("dispute:msg:%d", dispute.ID)),
+	))
+	if fromAdmin {
+		b.sendMessage(dispute.UserID, fmt.Sprintf("💬 *Администратор по спору #%d:*\n%s", dispute.ID, escapeMarkdown(text)), reply)
+	} else {
+		b.sendMessage(b.service.GetAdminChatID(), fmt.Sprintf(
+			"💬 *Пользователь* `%d` *по спору #%d:*\n%s", dispute.UserID, dispute.ID, escapeMarkdown(text),
+		), reply)
+	}
+	b.sendMessage(chatID, "✅ Сообщение отправлено.", GetMainMenu(user))
+}
+
+func disputeStatusText(status string) string {
+	switch status {
+	case models.DisputeStatusOpen:
+		return "⏳ открыт"
+	case models.DisputeStatusAccepted:
+		return "✅ признан"
+	case models.DisputeStatusRejected:
+		return "❌ отклонён"
+	case models.DisputeStatusAdjusted:
+		return "⚖️ закрыт корректировкой"
+	default:
+		return status
+	}
+}
+
+func disputeCardText(dispute *models.Dispute) string {
+	text := fmt.Sprintf(
+		"⚖️ *Спор #%d*\n👤 Пользователь: `%d`\n📌 Операция: %s\n📝 Претензия: %s\n📍 Статус: %s\n🕒 Открыт: %s",
+		dispute.ID, dispute.UserID, dispute.Subject(), escapeMarkdown(dispute.Reason),
+		disputeStatusText(dispute.Status), dispute.CreatedAt.Local().Format("02.01.2006 15:04"),
+	)
+	if dispute.ResolvedAt != nil {
+		text += fmt.Sprintf("\n✔️ Решение: `%s` %s, %s", signedAmount(dispute.Amount), dispute.Currency,
+			dispute.ResolvedAt.Local().Format("02.01.2006 15:04"))
+		if dispute.Resolution != "" {
+			text += "\n💬 " + escapeMarkdown(dispute.Resolution)
+		}
+	}
+	return text
+}
+
+// sendDisputeCard отправляет администратору карточку спора с перепиской и подсказкой, как его решить.
+func (b *Bot) sendDisputeCard(ctx context.Context, chatID int64, dispute *models.Dispute) {
+	var text strings.Builder
+	text.WriteString(disputeCardText(dispute))
+
+	messages, err := b.service.GetDisputeMessages(ctx, dispute.ID)
+	if err != nil {
+		b.logger.Errorf("Failed to get messages of dispute #%d: %v", dispute.ID, err)
+	}
+	if len(messages) > 0 {
+		text.WriteString("\n\n*Переписка:*\n")
+		for _, message := range messages {
+			author := "👤"
+			if message.FromAdmin {
+				author = "🛡"
+			}
+			text.WriteString(fmt.Sprintf("%s %s: %s\n", author, message.CreatedAt.Local().Format("02.01 15:04"), escapeMarkdown(message.Text)))
+		}
+	}
+
+	var keyboard interface{}
+	if dispute.Status == models.DisputeStatusOpen {
+		text.WriteString(fmt.Sprintf("\nРешение: `/resolve %d accept|reject|adjust [сумма] [комментарий]`", dispute.ID))
+		keyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✉️ Ответить", fmt.Sprintf("dispute:msg:%d", dispute.ID)),
+		))
+	}
+	b.sendLongMessage(chatID, text.String(), keyboard)
+}
+
+// handleOpenDisputes показывает администратору открытые споры.
+func (b *Bot) handleOpenDisputes(ctx context.Context, chatID int64) {
+	disputes, err := b.service.GetOpenDisputes(ctx)
+	if err != nil {
+		b.logger.Errorf("Failed to get open disputes: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось получить споры.", nil)
+		return
+	}
+	if len(disputes) == 0 {
+		b.sendMessage(chatID, "Открытых споров нет.", nil)
+		return
+	}
+	for i := range disputes {
+		b.sendDisputeCard(ctx, chatID, &disputes[i])
+	}
+}
+
+func (b *Bot) handleAdminDispute(ctx context.Context, chatID int64, arg string) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		b.sendMessage(chatID, "Использование: `/dispute <номер спора>`", nil)
+		return
+	}
+	dispute, err := b.service.GetDispute(ctx, id)
+	if err != nil {
+		b.logger.Errorf("Failed to get dispute #%d: %v", id, err)
+		b.sendMessage(chatID, "❌ Не удалось получить спор.", nil)
+		return
+	}
+	if dispute == nil {
+		b.sendMessage(chatID, fmt.Sprintf("❌ Спор #%d не найден.", id), nil)
+		return
+	}
+	b.sendDisputeCard(ctx, chatID, dispute)
+}
+
+// handleResolveCommand закрывает спор решением администратора:
+// accept — вернуть деньги по выводу или зачислить сумму по пополнению, reject — отклонить,
+// adjust — скорректировать баланс на сумму со знаком.
+func (b *Bot) handleResolveCommand(ctx context.Context, chatID, adminID int64, args []string) {
+	const usage = "Использование:\n" +
+		"`/resolve <номер> accept [сумма] [комментарий]` — признать: по выводу деньги вернутся на баланс, по пополнению зачислится сумма\n" +
+		"`/resolve <номер> reject [комментарий]` — отклонить\n" +
+		"`/resolve <номер> adjust <+/-сумма> [комментарий]` — скорректировать баланс"
+	if len(args) < 2 {
+		b.sendMessage(chatID, usage, nil)
+		return
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		b.sendMessage(chatID, usage, nil)
+		return
+	}
+
+	statuses := map[string]string{
+		"accept": models.DisputeStatusAccepted,
+		"reject": models.DisputeStatusRejected,
+		"adjust": models.DisputeStatusAdjusted,
+	}
+	status, ok := statuses[args[1]]
+	if !ok {
+		b.sendMessage(chatID, usage, nil)
+		return
+	}
+
+	rest := args[2:]
+	var amount money.Amount
+	if status != models.DisputeStatusRejected && len(rest) > 0 {
+		if parsed, err := money.Parse(rest[0]); err == nil {
+			amount = parsed
+			rest = rest[1:]
+		}
+	}
+	if status == models.DisputeStatusAdjusted {
+		// Как и в /adjust, знак обязателен, чтобы не перепутать зачисление и списание
+		if amount == 0 || !strings.HasPrefix(args[2], "+") && !strings.HasPrefix(args[2], "-") {
+			b.sendMessage(chatID, "❌ Укажите сумму со знаком: `+` — зачислить, `-` — списать.", nil)
+			return
+		}
+	}
+
+	dispute, err := b.service.ResolveDispute(ctx, adminID, id, status, amount, strings.Join(rest, " "))
+	if err != nil {
+		b.logger.Warnf("Failed to resolve dispute #%d: %v", id, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Спор не закрыт: %v", err), nil)
+		return
+	}
+
+	b.sendMessage(chatID, disputeCardText(dispute), nil)
+	b.notifyAboutDispute(ctx, dispute)
+}
+
+func (b *Bot) notifyAboutDispute(ctx context.Context, dispute *models.Dispute) {
+	var msg string
+	switch dispute.Status {
+	case models.DisputeStatusAccepted:
+		msg = fmt.Sprintf("✅ Спор #%d по операции «%s» решён в вашу пользу.", dispute.ID, dispute.Subject())
+		if dispute.Amount != 0 {
+			msg += fmt.Sprintf(" На баланс зачислено `%s` %s.", dispute.Amount, dispute.Currency)
+		}
+	case models.DisputeStatusAdjusted:
+		msg = fmt.Sprintf("⚖️ Спор #%d по операции «%s» закрыт корректировкой баланса на `%s` %s.",
+			dispute.ID, dispute.Subject(), signedAmount(dispute.Amount), dispute.Currency)
+	case models.DisputeStatusRejected:
+		msg = fmt.Sprintf("❌ Спор #%d по операции «%s» отклонён.", dispute.ID, dispute.Subject())
+	default:
+		return
+	}
+	if dispute.Resolution != "" {
+		msg += "\nКомментарий администратора: " + escapeMarkdown(dispute.Resolution)
+	}
+
+	user, err := b.service.GetUser(ctx, dispute.UserID)
+	if err != nil || user == nil {
+		b.logger.Errorf("Failed to get user %d to notify about dispute #%d: %v", dispute.UserID, dispute.ID, err)
+		b.sendMessage(dispute.UserID, msg, nil)
+		return
+	}
+	b.sendMessage(user.TelegramID, msg, GetMainMenu(user))
+}
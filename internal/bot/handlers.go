@@ -3,11 +3,9 @@ package bot
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/Fi44er/btc_bot/internal/models"
-	"github.com/Fi44er/btc_bot/utils"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -25,17 +23,52 @@ func (b *Bot) HandleUpdate(update tgbotapi.Update) {
 		case stateAwaitingCardNumber:
 			b.handleCardNumberInput(ctx, update, user)
 			return
-		case stateAwaitingWithdrawConfirmationAmount:
-			b.handleWithdrawConfirmation(ctx, chatID, user, text)
+		case stateAwaitingWithdrawAmount:
+			b.handleWithdrawAmountInput(ctx, chatID, user, text)
 			return
-		case stateAwaitingAdminNickname:
-			b.handleAdminNicknameInput(ctx, chatID, text)
+		case stateAwaitingPayoutDetails:
+			b.handlePayoutDetailsInput(ctx, chatID, text)
+			return
+		case stateAwaitingQuoteAmount:
+			b.handleQuoteAmountInput(ctx, chatID, user, text)
+			return
+		case stateAwaitingDisputeReason:
+			b.handleDisputeReasonInput(ctx, chatID, user, text)
+			return
+		case stateAwaitingDisputeMessage:
+			b.handleDisputeMessageInput(ctx, chatID, user, text)
+			return
+		}
+
+		if update.Message.IsCommand() && b.isAdmin(userID) && b.handleAdminCommand(ctx, update.Message) {
+			return
+		}
+
+		if update.Message.IsCommand() && update.Message.Command() == "statement" {
+			b.handleStatementCommand(ctx, chatID, user, strings.Fields(update.Message.CommandArguments()))
+			return
+		}
+
+		if update.Message.IsCommand() && update.Message.Command() == "dispute" {
+			b.handleDisputeRequest(ctx, chatID, user)
+			return
+		}
+
+		if update.Message.IsCommand() && update.Message.Command() == "limits" {
+			b.handleLimitsCommand(ctx, chatID, user)
+			return
+		}
+
+		if update.Message.IsCommand() && update.Message.Command() == "withdrawals" {
+			b.handleUserWithdrawals(ctx, chatID, user)
 			return
 		}
 
 		switch text {
 		case "/start":
 			b.handleStart(ctx, chatID, user)
+		case "/rate":
+			b.handleRateRequest(ctx, chatID, user)
 		case "💰 Получить адрес для пополнения":
 			b.handleAddressRequest(ctx, chatID, user)
 		case "💳 Указать номер карты", "💳 Изменить номер карты":
@@ -43,8 +76,20 @@ func (b *Bot) HandleUpdate(update tgbotapi.Update) {
 			b.sendMessage(chatID, "Пожалуйста, отправьте номер вашей карты:", tgbotapi.NewRemoveKeyboard(true))
 		case "📊 Посмотреть баланс":
 			b.handleBalanceRequest(ctx, chatID, user)
-		case "✅ Пришло на карту":
+		case "💸 Вывести средства":
 			b.handleWithdrawRequest(ctx, chatID, user)
+		case "🔄 Проверить пополнение":
+			b.handleDepositCheck(ctx, chatID, user)
+		case "🔒 Зафиксировать курс":
+			b.handleQuoteRequest(ctx, chatID, user)
+		case "📜 История":
+			b.handleHistoryRequest(ctx, chatID, user)
+		case "💱 Сменить валюту":
+			b.handleCurrencyRequest(ctx, chatID, user)
+		case "🧾 Выписка":
+			b.handleStatementCommand(ctx, chatID, user, nil)
+		case "⚖️ Спор":
+			b.handleDisputeRequest(ctx, chatID, user)
 		default:
 			b.sendMessage(chatID, "Неизвестная команда. Используйте меню.", GetMainMenu(user))
 		}
@@ -83,13 +128,36 @@ func (b *Bot) handleAddressRequest(ctx context.Context, chatID int64, user *mode
 
 func (b *Bot) handleCallbackQuery(callback *tgbotapi.CallbackQuery) {
 	ctx := context.Background()
+
+	// Кнопки пользователя
+	switch {
+	case strings.HasPrefix(callback.Data, "currency:"):
+		b.handleCurrencyCallback(ctx, callback)
+		return
+	case strings.HasPrefix(callback.Data, "history:"):
+		b.handleHistoryCallback(ctx, callback)
+		return
+	case strings.HasPrefix(callback.Data, "withdraw:"):
+		b.handleWithdrawConfirmCallback(ctx, callback)
+		return
+	case strings.HasPrefix(callback.Data, "payout:"):
+		b.handlePayoutReceiptCallback(ctx, callback)
+		return
+	case strings.HasPrefix(callback.Data, "dispute:"):
+		b.handleDisputeCallback(ctx, callback)
+		return
+	}
+
 	if !b.isAdmin(callback.From.ID) {
 		b.answerCallback(callback.ID, "Это действие доступно только администратору.")
 		return
 	}
 
-	if strings.HasPrefix(callback.Data, "contact_user:") {
-		b.handleContactUserCallback(ctx, callback)
+	switch {
+	case strings.HasPrefix(callback.Data, "withdrawal:"):
+		b.handleWithdrawalCallback(ctx, callback)
+	case strings.HasPrefix(callback.Data, "adjust:"):
+		b.handleAdjustCallback(ctx, callback)
 	}
 }
 
@@ -110,67 +178,17 @@ func (b *Bot) handleCardNumberInput(ctx context.Context, update tgbotapi.Update,
 	b.sendMessage(chatID, "Выберите действие в меню:", GetMainMenu(user))
 }
 
-func (b *Bot) handleBalanceRequest(_ context.Context, chatID int64, user *models.User) {
-	balance := utils.RoundTo(user.Balance, 2)
-	msgText := fmt.Sprintf("Ваш текущий баланс: %.2f RUB", balance)
-	b.sendMessage(chatID, msgText, GetMainMenu(user))
-}
-
-func (b *Bot) handleContactUserCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
-	adminID := callback.From.ID
-	parts := strings.Split(strings.TrimPrefix(callback.Data, "contact_user:"), ":")
-	if len(parts) != 1 {
-		b.logger.Errorf("Invalid callback data for contact_user: %s", callback.Data)
-		b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
-		return
-	}
-	targetUserIDStr := parts[0]
-
-	b.setUserActionData(adminID, targetUserIDStr)
-	b.setState(adminID, stateAwaitingAdminNickname)
-
-	edit := tgbotapi.NewEditMessageReplyMarkup(callback.Message.Chat.ID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{})
-	b.API.Send(edit)
-
-	msgText := "Введите ваш никнейм (например, @my\\_nickname), чтобы отправить его пользователю."
-	b.sendMessage(adminID, msgText, tgbotapi.NewRemoveKeyboard(true))
-	b.answerCallback(callback.ID, "")
-}
-
-func (b *Bot) handleAdminNicknameInput(ctx context.Context, adminChatID int64, nickname string) {
-	targetUserIDStr := b.getUserActionData(adminChatID)
-	targetUserID, err := strconv.ParseInt(targetUserIDStr, 10, 64)
+func (b *Bot) handleBalanceRequest(ctx context.Context, chatID int64, user *models.User) {
+	reserved, err := b.service.GetReservedBalance(ctx, user.TelegramID)
 	if err != nil {
-		b.logger.Errorf("Failed to parse target user ID from action data: %v", err)
+		b.logger.Errorf("Failed to get reserved balance of user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, fmt.Sprintf("Ваш текущий баланс: %s %s", user.Balance, user.Currency), GetMainMenu(user))
 		return
 	}
 
-	b.clearUserActionData(adminChatID)
-	b.setState(adminChatID, stateDefault)
-
-	markdownEscaper := strings.NewReplacer(
-		"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]",
-		"(", "\\(", ")", "\\)", "~", "\\~", "`", "\\`",
-		">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-",
-		"=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}",
-		".", "\\.", "!", "\\!",
-	)
-	safeNickname := markdownEscaper.Replace(nickname)
-
-	userMsg := fmt.Sprintf(
-		"🔵 Для организации вывода средств, пожалуйста, свяжитесь с администратором: %s\n\n"+
-			"После получения перевода на карту, не забудьте нажать кнопку '✅ Подтвердить вывод' в главном меню.",
-		safeNickname,
+	msgText := fmt.Sprintf(
+		"Доступно: `%[1]s` %[3]s\nВ резерве по заявкам на вывод: `%[2]s` %[3]s",
+		user.Balance, reserved, user.Currency,
 	)
-
-	user, err := b.service.GetUser(ctx, targetUserID)
-	if err != nil {
-		b.logger.Errorf("Could not get user %d to send admin contact: %v", targetUserID, err)
-		b.sendMessage(adminChatID, fmt.Sprintf("Не удалось найти пользователя %d для отправки сообщения.", targetUserID), nil)
-		return
-	}
-	b.sendMessage(targetUserID, userMsg, GetMainMenu(user))
-
-	adminConfirmMsg := fmt.Sprintf("✅ Ваши контактные данные (%s) отправлены пользователю %d.", safeNickname, targetUserID)
-	b.sendMessage(adminChatID, adminConfirmMsg, nil)
+	b.sendMessage(chatID, msgText, GetMainMenu(user))
 }
@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (b *Bot) handleHistoryRequest(ctx context.Context, chatID int64, user *models.User) {
+	page, err := b.service.GetHistory(ctx, user.TelegramID, 0)
+	if err != nil {
+		b.logger.Errorf("Failed to get history of user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить историю. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.historyText(page))
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	msg.DisableWebPagePreview = true
+	if keyboard := historyKeyboard(page); keyboard != nil {
+		msg.ReplyMarkup = keyboard
+	}
+	if _, err := b.API.Send(msg); err != nil {
+		b.logger.Errorf("Failed to send history: %v", err)
+	}
+}
+
+// handleHistoryCallback листает историю в том же сообщении.
+func (b *Bot) handleHistoryCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	pageNum, err := strconv.Atoi(strings.TrimPrefix(callback.Data, "history:"))
+	if err != nil {
+		b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
+		return
+	}
+
+	page, err := b.service.GetHistory(ctx, callback.From.ID, pageNum)
+	if err != nil {
+		b.logger.Errorf("Failed to get history of user %d: %v", callback.From.ID, err)
+		b.answerCallback(callback.ID, "❌ Не удалось получить историю.")
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, b.historyText(page))
+	edit.ParseMode = tgbotapi.ModeMarkdown
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = historyKeyboard(page)
+	if _, err := b.API.Send(edit); err != nil {
+		b.logger.Errorf("Failed to edit history message: %v", err)
+	}
+	b.answerCallback(callback.ID, "")
+}
+
+func historyKeyboard(page *models.HistoryPage) *tgbotapi.InlineKeyboardMarkup {
+	if page.Pages <= 1 {
+		return nil
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if page.Page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("⬅️ Новее", fmt.Sprintf("history:%d", page.Page-1)))
+	}
+	if page.Page < page.Pages-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Старее ➡️", fmt.Sprintf("history:%d", page.Page+1)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &keyboard
+}
+
+func (b *Bot) historyText(page *models.HistoryPage) string {
+	if len(page.Items) == 0 {
+		return "📜 Операций пока нет."
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📜 История операций (стр. %d из %d)\n", page.Page+1, page.Pages))
+
+	for _, item := range page.Items {
+		date := "сейчас"
+		if !item.Time.IsZero() {
+			date = item.Time.Local().Format("02.01.2006 15:04")
+		}
+		sb.WriteString(fmt.Sprintf("\n%s %s — %s\n", historyKindIcon(item.Kind), date, historyKindText(item.Kind)))
+
+		if item.Kind == models.LedgerEntryDeposit {
+			sb.WriteString(fmt.Sprintf("`%.8f` BTC", item.AmountSats.ToBTC()))
+			if item.Status == models.TransactionStatusCredited {
+				sb.WriteString(fmt.Sprintf(" по курсу `%.2f` → `%+.2f` %s", item.Rate, item.Amount.Major(), item.Currency))
+			}
+			sb.WriteString("\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("`%+.2f` %s\n", item.Amount.Major(), item.Currency))
+		}
+
+		sb.WriteString(historyStatusText(item.Status))
+		if item.Description != "" {
+			sb.WriteString(", " + item.Description)
+		}
+		sb.WriteString("\n")
+
+		if item.TxID != "" {
+			sb.WriteString(fmt.Sprintf("[%s…](%s%s)\n", item.TxID[:min(len(item.TxID), 16)], b.config.ExplorerTxURL, item.TxID))
+		}
+	}
+
+	return sb.String()
+}
+
+func historyKindIcon(kind string) string {
+	switch kind {
+	case models.LedgerEntryDeposit:
+		return "📥"
+	case models.LedgerEntryWithdrawal, models.LedgerEntryFee:
+		return "📤"
+	default:
+		return "🛠"
+	}
+}
+
+func historyKindText(kind string) string {
+	switch kind {
+	case models.LedgerEntryDeposit:
+		return "пополнение"
+	case models.LedgerEntryWithdrawal:
+		return "вывод"
+	case models.LedgerEntryFee:
+		return "комиссия"
+	case models.LedgerEntryAdjustment:
+		return "корректировка"
+	case models.LedgerEntryReversal:
+		return "сторно"
+	default:
+		return kind
+	}
+}
+
+func historyStatusText(status string) string {
+	switch status {
+	case "":
+		return "✅ проведено"
+	case models.TransactionStatusCredited:
+		return "✅ зачислено"
+	case models.TransactionStatusHeld:
+		return "🟡 ждёт накопления минимальной суммы"
+	case models.TransactionStatusIgnored:
+		return "⚪️ не зачисляется"
+	case models.TransactionStatusConfirming:
+		return "🔄 подтверждается"
+	case "pending":
+		return "⏳ в обработке"
+	case models.WithdrawalStatusApproved:
+		return "✅ одобрено, ждёт перевода"
+	case models.WithdrawalStatusPaid:
+		return "📨 переведено, подтвердите получение"
+	case models.WithdrawalStatusDisputed:
+		return "⚠️ перевод оспорен"
+	default:
+		return status
+	}
+}
@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+func limitAmountText(amount money.Amount, currency string) string {
+	if amount == 0 {
+		return "без ограничения"
+	}
+	return fmt.Sprintf("`%s` %s", amount, currency)
+}
+
+// withdrawalLimitsText описывает действующие лимиты на вывод и сколько из них осталось.
+func withdrawalLimitsText(l *models.WithdrawalLimits) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Сумма одной заявки: от %s до %s\n",
+		limitAmountText(l.Min, l.Currency), limitAmountText(l.Max, l.Currency)))
+	for _, usage := range l.Usage {
+		scope := "Ваш"
+		if usage.Scope == models.WithdrawalLimitScopeGlobal {
+			scope = "Общий"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s лимит: `%s` из `%s` %s использовано\n",
+			scope, usage.Title, usage.Used, usage.Limit, l.Currency))
+	}
+	if l.CooldownUntil != nil {
+		sb.WriteString(fmt.Sprintf("⏳ После смены карты вывод доступен с %s\n", l.CooldownUntil.Local().Format("02.01.2006 15:04")))
+	}
+	return sb.String()
+}
+
+// handleLimitsCommand показывает пользователю его лимиты на вывод.
+func (b *Bot) handleLimitsCommand(ctx context.Context, chatID int64, user *models.User) {
+	limits, err := b.service.GetWithdrawalLimits(ctx, user.TelegramID)
+	if err != nil {
+		b.logger.Errorf("Failed to get withdrawal limits of user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить лимиты. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	b.sendMessage(chatID, "📏 *Лимиты на вывод*\n\n"+withdrawalLimitsText(limits), GetMainMenu(user))
+}
+
+// handleAdminLimits показывает или меняет лимиты пользователя:
+// `/limits <id>`, `/limits <id> set ключ=значение ...`, `/limits <id> reset`.
+func (b *Bot) handleAdminLimits(ctx context.Context, chatID, adminID, userID int64, args []string) {
+	const usage = "Использование:\n" +
+		"`/limits <telegram_id>` — показать лимиты\n" +
+		"`/limits <telegram_id> set min=500 max=100000 day=50000 week=0 month=default global=off cooldown=off` — " +
+		"назначить в текущей валюте пользователя (0 — без ограничения, default — как в настройках, on/off — действуют ли общие лимиты и пауза после смены карты)\n" +
+		"`/limits <telegram_id> reset` — вернуть лимиты из настроек"
+
+	if len(args) > 0 {
+		var err error
+		switch args[0] {
+		case "reset":
+			err = b.service.ResetWithdrawalLimitOverride(ctx, adminID, userID)
+		case "set":
+			err = b.setLimitOverride(ctx, adminID, userID, args[1:])
+		default:
+			b.sendMessage(chatID, usage, nil)
+			return
+		}
+		if err != nil {
+			b.logger.Warnf("Failed to change withdrawal limits of user %d: %v", userID, err)
+			b.sendMessage(chatID, fmt.Sprintf("❌ Лимиты не изменены: %v", err), nil)
+			return
+		}
+	}
+
+	limits, err := b.service.GetWithdrawalLimits(ctx, userID)
+	if err != nil {
+		b.logger.Errorf("Failed to get withdrawal limits of user %d: %v", userID, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось получить лимиты: %v", err), nil)
+		return
+	}
+
+	text := fmt.Sprintf("📏 *Лимиты на вывод пользователя* `%d`\n\n%s", userID, withdrawalLimitsText(limits))
+	if o := limits.Override; o != nil {
+		text += fmt.Sprintf("\nНазначены администратором `%d`, %s", o.SetBy, o.UpdatedAt.Local().Format("02.01.2006 15:04"))
+		if o.Currency != limits.Currency {
+			text += fmt.Sprintf("\nСуммы назначены в %s и после смены валюты не действуют, назначьте их заново", o.Currency)
+		}
+		if o.IgnoreGlobal {
+			text += "\nОбщие лимиты не действуют"
+		}
+		if o.IgnoreCardCooldown {
+			text += "\nПауза после смены карты не действует"
+		}
+	} else {
+		text += "\nДействуют лимиты из настроек"
+	}
+	b.sendMessage(chatID, text, nil)
+}
+
+// setLimitOverride меняет назначенные пользователю лимиты: не упомянутые ключи остаются прежними.
+func (b *Bot) setLimitOverride(ctx context.Context, adminID, userID int64, args []string) error {
+	if len(args) == 0 {
+		return errors.New("не указано, какие лимиты менять")
+	}
+
+	limits, err := b.service.GetWithdrawalLimits(ctx, userID)
+	if err != nil {
+		return err
+	}
+	override := &models.WithdrawalLimitOverride{UserID: userID}
+	if limits.Override != nil {
+		override = limits.Override
+		if override.Currency != limits.Currency {
+			// Суммы в прежней валюте пользователя в новую не переносятся
+			override.MinAmount, override.MaxAmount = nil, nil
+			override.Daily, override.Weekly, override.Monthly = nil, nil, nil
+		}
+	}
+
+	amounts := map[string]**money.Amount{
+		"min":   &override.MinAmount,
+		"max":   &override.MaxAmount,
+		"day":   &override.Daily,
+		"week":  &override.Weekly,
+		"month": &override.Monthly,
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("ожидается ключ=значение, получено %q", arg)
+		}
+
+		switch key {
+		case "global", "cooldown":
+			if value != "on" && value != "off" {
+				return fmt.Errorf("%s принимает on или off", key)
+			}
+			if key == "global" {
+				override.IgnoreGlobal = value == "off"
+			} else {
+				override.IgnoreCardCooldown = value == "off"
+			}
+			continue
+		}
+
+		field, known := amounts[key]
+		if !known {
+			return fmt.Errorf("неизвестный ключ %q", key)
+		}
+		if value == "default" {
+			*field = nil
+			continue
+		}
+		amount, err := money.Parse(value)
+		if err != nil || amount < 0 {
+			return fmt.Errorf("неверная сумма %q", value)
+		}
+		*field = &amount
+	}
+
+	return b.service.SetWithdrawalLimitOverride(ctx, adminID, override)
+}
+
+// parseLimitsTarget возвращает telegram_id из аргументов /limits администратора.
+func parseLimitsTarget(args []string) (int64, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	return userID, err == nil
+}
@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/internal/service"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const payoutTimeLayout = "2006-01-02 15:04"
+
+// handlePayoutRequest просит администратора ввести данные перевода по заявке.
+func (b *Bot) handlePayoutRequest(callback *tgbotapi.CallbackQuery, withdrawalID int64) {
+	adminID := callback.From.ID
+	b.setUserActionData(adminID, strconv.FormatInt(withdrawalID, 10))
+	b.setState(adminID, stateAwaitingPayoutDetails)
+
+	b.answerCallback(callback.ID, "")
+	b.sendMessage(callback.Message.Chat.ID, fmt.Sprintf(
+		"Введите перевод по заявке #%d одной строкой:\n`<сумма> <номер операции> [ГГГГ-ММ-ДД ЧЧ:ММ]`\n\n"+
+			"Без времени перевод записывается текущим временем.",
+		withdrawalID,
+	), nil)
+}
+
+// handlePayoutDetailsInput записывает перевод, введённый администратором, и просит пользователя
+// подтвердить его получение.
+func (b *Bot) handlePayoutDetailsInput(ctx context.Context, chatID int64, text string) {
+	adminID := chatID
+	withdrawalID, err := strconv.ParseInt(b.getUserActionData(adminID), 10, 64)
+	b.clearUserActionData(adminID)
+	b.setState(adminID, stateDefault)
+	if err != nil {
+		b.logger.Errorf("Failed to parse withdrawal ID from action data: %v", err)
+		return
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) != 2 && len(fields) != 4 {
+		b.sendMessage(chatID, "❌ Ожидается `<сумма> <номер операции> [ГГГГ-ММ-ДД ЧЧ:ММ]`. Перевод не записан.", nil)
+		return
+	}
+
+	amount, err := money.Parse(fields[0])
+	if err != nil || amount <= 0 {
+		b.sendMessage(chatID, "❌ Неверная сумма. Перевод не записан.", nil)
+		return
+	}
+
+	paidAt := time.Now()
+	if len(fields) == 4 {
+		paidAt, err = time.ParseInLocation(payoutTimeLayout, fields[2]+" "+fields[3], time.Local)
+		if err != nil {
+			b.sendMessage(chatID, "❌ Неверное время перевода. Перевод не записан.", nil)
+			return
+		}
+	}
+
+	withdrawal, err := b.service.RecordPayout(ctx, adminID, withdrawalID, amount, fields[1], paidAt)
+	if errors.Is(err, service.ErrWithdrawalStatus) || errors.Is(err, service.ErrDisputeOpen) {
+		b.sendMessage(chatID, fmt.Sprintf("❌ %v. Перевод не записан.", err), nil)
+		return
+	}
+	if err != nil || withdrawal == nil {
+		b.logger.Errorf("Failed to record payout for withdrawal #%d: %v", withdrawalID, err)
+		b.sendMessage(chatID, "❌ Не удалось записать перевод.", nil)
+		return
+	}
+
+	// Перевод не на сумму заявки записан, но пользователю на подтверждение не уходит
+	if withdrawal.Status == models.WithdrawalStatusDisputed {
+		adminChatID := b.service.GetAdminChatID()
+		b.sendMessage(adminChatID, fmt.Sprintf(
+			"⚠️ По заявке #%d записан перевод `%s` %s, а по заявке положено `%s` %s.\n\n"+
+				"Перевод сохранён, заявка заблокирована и не может быть завершена. "+
+				"Исправьте выплату и запишите перевод заново на сумму заявки.",
+			withdrawal.ID, withdrawal.PayoutAmount, withdrawal.Currency, withdrawal.Amount, withdrawal.Currency,
+		), nil)
+		b.sendWithdrawalCard(adminChatID, withdrawal)
+		return
+	}
+
+	b.sendWithdrawalCard(chatID, withdrawal)
+	b.notifyAboutWithdrawal(ctx, withdrawal)
+}
+
+// notifyAboutPayout просит пользователя подтвердить получение записанного перевода или оспорить его.
+func (b *Bot) notifyAboutPayout(withdrawal *models.Withdrawal) {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Получил", fmt.Sprintf("payout:confirm:%d", withdrawal.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("⚠️ Не получил / другая сумма", fmt.Sprintf("payout:dispute:%d", withdrawal.ID)),
+	))
+	b.sendMessage(withdrawal.UserID, fmt.Sprintf(
+		"📨 По заявке #%d администратор перевёл деньги на карту `%s`.\n\n%s\n\n"+
+			"Проверьте поступление и подтвердите получение. Если денег нет или сумма другая — сообщите об этом.",
+		withdrawal.ID, withdrawal.CardNumber, payoutText(withdrawal),
+	), keyboard)
+}
+
+func payoutText(withdrawal *models.Withdrawal) string {
+	paidAt := "—"
+	if withdrawal.PaidAt != nil {
+		paidAt = withdrawal.PaidAt.Local().Format("02.01.2006 15:04")
+	}
+	return fmt.Sprintf(
+		"💸 *Перевод:* `%s` %s\n🧾 *Номер операции:* `%s`\n🕒 *Время:* %s",
+		withdrawal.PayoutAmount, withdrawal.Currency, withdrawal.PayoutReference, paidAt,
+	)
+}
+
+// handlePayoutReceiptCallback подтверждает получение перевода или оспаривает его.
+// Оспоренный перевод не даёт завершить заявку, администратор получает предупреждение.
+func (b *Bot) handlePayoutReceiptCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "payout:"), ":")
+	if len(parts) != 2 {
+		b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
+		return
+	}
+	withdrawalID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
+		return
+	}
+
+	userID := callback.From.ID
+	switch parts[0] {
+	case "confirm":
+		err = b.service.ConfirmPayout(ctx, userID, withdrawalID)
+	case "dispute":
+		err = b.service.DisputePayout(ctx, userID, withdrawalID)
+	default:
+		b.answerCallback(callback.ID, "Ошибка: неизвестное действие.")
+		return
+	}
+	if errors.Is(err, service.ErrWithdrawalStatus) {
+		b.answerCallback(callback.ID, "Перевод уже обработан.")
+		return
+	}
+	if errors.Is(err, service.ErrDisputeOpen) {
+		b.answerCallback(callback.ID, "По заявке открыт спор, дождитесь его решения.")
+		return
+	}
+	if err != nil {
+		b.logger.Errorf("Failed to %s payout of withdrawal #%d: %v", parts[0], withdrawalID, err)
+		b.answerCallback(callback.ID, "❌ Не удалось обработать ответ. Попробуйте позже.")
+		return
+	}
+	b.answerCallback(callback.ID, "")
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(callback.Message.Chat.ID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	if _, err := b.API.Send(edit); err != nil {
+		b.logger.Errorf("Failed to remove payout keyboard: %v", err)
+	}
+
+	withdrawal, err := b.service.GetWithdrawalByID(ctx, withdrawalID)
+	if err != nil || withdrawal == nil {
+		b.logger.Errorf("Failed to reload withdrawal #%d: %v", withdrawalID, err)
+		return
+	}
+
+	adminChatID := b.service.GetAdminChatID()
+	if withdrawal.Status == models.WithdrawalStatusDisputed {
+		b.sendMessage(callback.Message.Chat.ID,
+			"⚠️ Мы сообщили администратору, что перевод не совпадает. Заявка не будет завершена, пока это не исправлено.", nil)
+		b.sendMessage(adminChatID, fmt.Sprintf(
+			"⚠️ Пользователь `%d` оспорил перевод по заявке #%d. Заявка не может быть завершена, пока перевод не записан заново.",
+			withdrawal.UserID, withdrawal.ID,
+		), nil)
+		b.sendWithdrawalCard(adminChatID, withdrawal)
+		return
+	}
+
+	b.notifyAboutWithdrawal(ctx, withdrawal)
+	b.sendMessage(adminChatID, fmt.Sprintf("✅ Пользователь `%d` подтвердил получение перевода по заявке #%d.", withdrawal.UserID, withdrawal.ID), nil)
+}
@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/internal/service"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (b *Bot) handleQuoteRequest(ctx context.Context, chatID int64, user *models.User) {
+	b.setState(user.TelegramID, stateAwaitingQuoteAmount)
+	msg := fmt.Sprintf(
+		"Введите сумму в %s, которую хотите получить на баланс.\n\n"+
+			"Мы зафиксируем курс на %s и покажем, сколько BTC нужно отправить.",
+		user.Currency, b.config.QuoteTTL,
+	)
+	b.sendMessage(chatID, msg, tgbotapi.NewRemoveKeyboard(true))
+}
+
+func (b *Bot) handleQuoteAmountInput(ctx context.Context, chatID int64, user *models.User, text string) {
+	b.setState(user.TelegramID, stateDefault)
+
+	amount, err := money.Parse(text)
+	if err != nil || amount <= 0 {
+		b.sendMessage(chatID, "❌ Неверная сумма. Введите положительное число. Операция отменена.", GetMainMenu(user))
+		return
+	}
+
+	quote, err := b.service.CreateQuote(ctx, user.TelegramID, amount)
+	if err != nil {
+		b.logger.Errorf("Failed to create quote for user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось зафиксировать курс: %v", err), GetMainMenu(user))
+		return
+	}
+
+	msgText := fmt.Sprintf(
+		"🔒 Курс зафиксирован до %s\n\n"+
+			"💰 Вы получите: `%s` %s\n"+
+			"₿ Отправьте: `%.8f` BTC\n"+
+			"📈 Курс: `%.2f` %s\n"+
+			"🧾 Адрес: `%s`\n\n"+
+			"Ссылка для оплаты:\n`%s`\n\n"+
+			"Сумма может отличаться от указанной не больше чем на %.2f%%. "+
+			"Если оплата придёт после истечения срока или с большим отклонением, она будет зачислена по текущему курсу.",
+		quote.ExpiresAt.Local().Format("15:04 02.01.2006"),
+		quote.AmountFiat, quote.Currency,
+		quote.AmountSats.ToBTC(),
+		quote.Rate, quote.Currency,
+		quote.Address,
+		service.PaymentURI(quote),
+		b.config.QuoteTolerancePercent,
+	)
+	b.sendMessage(chatID, msgText, GetMainMenu(user))
+}
@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+)
+
+const dateLayout = "2006-01-02"
+
+// parsePeriod разбирает необязательные даты начала и конца периода в формате ГГГГ-ММ-ДД.
+// Дата конца включается в период; если даты не указаны, остаются from и to.
+func parsePeriod(args []string, from, to time.Time) (time.Time, time.Time, error) {
+	if len(args) >= 1 {
+		date, err := time.ParseInLocation(dateLayout, args[0], time.Local)
+		if err != nil {
+			return from, to, errors.New("Неверная дата начала периода")
+		}
+		from = date
+	}
+	if len(args) >= 2 {
+		date, err := time.ParseInLocation(dateLayout, args[1], time.Local)
+		if err != nil {
+			return from, to, errors.New("Неверная дата конца периода")
+		}
+		to = date.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("Начало периода позже его конца")
+	}
+	return from, to, nil
+}
+
+func (b *Bot) startRateSnapshotter() {
+	if b.config.RateSnapshotInterval <= 0 {
+		b.logger.Info("Rate snapshotter disabled")
+		return
+	}
+
+	ticker := time.NewTicker(b.config.RateSnapshotInterval)
+	defer ticker.Stop()
+
+	b.logger.Info("Rate snapshotter started")
+
+	for range ticker.C {
+		if err := b.service.SnapshotRate(context.Background()); err != nil {
+			b.logger.Warnf("Failed to snapshot BTC rates: %v", err)
+		}
+	}
+}
+
+func (b *Bot) handleRateRequest(ctx context.Context, chatID int64, user *models.User) {
+	summary, err := b.service.GetRateSummary(ctx, user.Currency)
+	if err != nil {
+		b.logger.Errorf("Failed to get rate summary: %v", err)
+		b.sendMessage(chatID, "❌ Курс временно недоступен. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+
+	msgText := fmt.Sprintf(
+		"📈 Курс BTC/%s: `%.2f` %s\n🕒 %s (%s)",
+		user.Currency, summary.Current.Value, user.Currency, formatRateTime(&summary.Current.FetchedAt), summary.Current.Source,
+	)
+	if summary.DayAgo != nil {
+		change := summary.Current.Value - summary.DayAgo.Value
+		msgText += fmt.Sprintf("\n\nЗа 24 ч: `%+.2f` %s (`%+.2f%%`)", change, user.Currency, change/summary.DayAgo.Value*100)
+	}
+
+	b.sendMessage(chatID, msgText, GetMainMenu(user))
+}
+
+// handleRateHistory показывает администратору сводку по курсу за период и присылает выгрузку в CSV.
+// Без аргументов берутся последние сутки в валюте по умолчанию, даты указываются как ГГГГ-ММ-ДД включительно.
+func (b *Bot) handleRateHistory(ctx context.Context, chatID int64, args []string) {
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+
+	currency := b.config.DefaultCurrency
+	if len(args) > 0 && b.config.IsSupportedCurrency(strings.ToUpper(args[0])) {
+		currency = strings.ToUpper(args[0])
+		args = args[1:]
+	}
+
+	if len(args) > 2 {
+		b.sendMessage(chatID, "Использование: `/ratehistory [валюта] [с ГГГГ-ММ-ДД] [по ГГГГ-ММ-ДД]`", nil)
+		return
+	}
+	from, to, err := parsePeriod(args, from, to)
+	if err != nil {
+		b.sendMessage(chatID, fmt.Sprintf("❌ %v.", err), nil)
+		return
+	}
+
+	history, err := b.service.GetRateHistory(ctx, currency, from, to)
+	if err != nil {
+		b.logger.Errorf("Failed to get rate history: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось получить историю курса.", nil)
+		return
+	}
+
+	if len(history) == 0 {
+		b.sendMessage(chatID, "За этот период курсов не сохранено.", nil)
+		return
+	}
+
+	minRate, maxRate, sum := history[0].Value, history[0].Value, 0.0
+	for _, rate := range history {
+		minRate = min(minRate, rate.Value)
+		maxRate = max(maxRate, rate.Value)
+		sum += rate.Value
+	}
+	first, last := history[0], history[len(history)-1]
+
+	b.sendMessage(chatID, fmt.Sprintf(
+		"📈 История курса BTC/%[1]s\n%[2]s — %[3]s\n\n"+
+			"Записей: %[4]d\n"+
+			"Начало: `%.2[5]f` %[1]s\n"+
+			"Конец: `%.2[6]f` %[1]s\n"+
+			"Мин: `%.2[7]f` %[1]s\n"+
+			"Макс: `%.2[8]f` %[1]s\n"+
+			"Среднее: `%.2[9]f` %[1]s",
+		currency, formatRateTime(&first.FetchedAt), formatRateTime(&last.FetchedAt),
+		len(history), first.Value, last.Value, minRate, maxRate, sum/float64(len(history)),
+	), nil)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"fetched_at", "currency", "rate", "source"})
+	for _, rate := range history {
+		_ = w.Write([]string{
+			rate.FetchedAt.Format(time.RFC3339),
+			rate.Currency,
+			strconv.FormatFloat(rate.Value, 'f', 2, 64),
+			rate.Source,
+		})
+	}
+	w.Flush()
+
+	fileName := fmt.Sprintf("btc_%s_%s_%s.csv", strings.ToLower(currency), from.Format(dateLayout), to.Add(-time.Second).Format(dateLayout))
+	b.sendDocument(chatID, fileName, buf.Bytes())
+}
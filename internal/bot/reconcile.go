@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+)
+
+func (b *Bot) startReconciler() {
+	if b.config.ReconcileInterval <= 0 {
+		b.logger.Info("Reconciliation disabled")
+		return
+	}
+
+	ticker := time.NewTicker(b.config.ReconcileInterval)
+	defer ticker.Stop()
+
+	b.logger.Info("Reconciler started")
+
+	for range ticker.C {
+		b.handleReconcile(context.Background(), b.service.GetAdminChatID())
+	}
+}
+
+// handleReconcile запускает сверку и отправляет отчёт в чат chatID.
+func (b *Bot) handleReconcile(ctx context.Context, chatID int64) {
+	report, err := b.service.Reconcile(ctx)
+	if err != nil {
+		b.logger.Errorf("Reconciliation failed: %v", err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Сверка не выполнена: %v", err), nil)
+		return
+	}
+
+	b.sendLongMessage(chatID, reconcileReportText(report), nil)
+}
+
+func reconcileReportText(report *models.ReconcileReport) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(
+		"🧮 Сверка от %s\n\n"+
+			"Пользователей: %d\n"+
+			"Подтверждено в сети: `%.8f` BTC\n"+
+			"Учтено в базе: `%.8f` BTC\n",
+		formatRateTime(&report.StartedAt), report.Users, report.ChainSats.ToBTC(), report.StoredSats.ToBTC(),
+	))
+
+	if len(report.Issues) == 0 {
+		sb.WriteString("\n✅ Расхождений не найдено.")
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("\n⚠️ Найдено расхождений: %d\n", len(report.Issues)))
+	for _, issue := range report.Issues {
+		sb.WriteString(fmt.Sprintf("\n👤 `%d` %s: %s", issue.UserID, reconcileIssueText(issue.Kind), issue.Detail))
+	}
+	return sb.String()
+}
+
+func reconcileIssueText(kind string) string {
+	switch kind {
+	case models.ReconcileMissingDeposit:
+		return "❓ нет записи о пополнении"
+	case models.ReconcileUncreditedDeposit:
+		return "⏸ не зачислено"
+	case models.ReconcileUnknownRow:
+		return "👻 нет в сети"
+	case models.ReconcileUnpostedCredit:
+		return "📒 нет в журнале"
+	case models.ReconcileBalanceDrift:
+		return "⚖️ баланс не сходится"
+	case models.ReconcileNegativeBalance:
+		return "🔻 отрицательный баланс"
+	case models.ReconcileChainError:
+		return "🌐 ошибка сети"
+	default:
+		return kind
+	}
+}
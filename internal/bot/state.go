@@ -1,16 +1,34 @@
 package bot
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 const (
-	stateDefault                            = ""
-	stateAwaitingCardNumber                 = "awaiting_card_number"
-	stateAwaitingWithdrawConfirmationAmount = "awaiting_withdraw_confirmation" // Новое состояние для подтверждения вывода
-	stateAwaitingAdminNickname              = "awaiting_admin_nickname"        // Новое состояние для админа
+	stateDefault                = ""
+	stateAwaitingCardNumber     = "awaiting_card_number"
+	stateAwaitingWithdrawAmount = "awaiting_withdraw_amount"
+	stateAwaitingPayoutDetails  = "awaiting_payout_details"
+	stateAwaitingQuoteAmount    = "awaiting_quote_amount"
+	stateAwaitingDisputeReason  = "awaiting_dispute_reason"
+	stateAwaitingDisputeMessage = "awaiting_dispute_message"
 )
 
+// newCallbackID выдаёт случайный id для кнопок, которые должны срабатывать один раз
+// и только под тем сообщением, для которого созданы.
+func newCallbackID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
 func (b *Bot) sendMessage(chatID int64, text string, replyMarkup interface{}) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = tgbotapi.ModeMarkdown
@@ -22,6 +40,28 @@ func (b *Bot) sendMessage(chatID int64, text string, replyMarkup interface{}) {
 	}
 }
 
+// sendLongMessage разбивает текст по строкам на части, укладывающиеся в лимит Telegram.
+func (b *Bot) sendLongMessage(chatID int64, text string, replyMarkup interface{}) {
+	const maxLen = 4000
+
+	var chunk strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if chunk.Len() > 0 && chunk.Len()+len(line) > maxLen {
+			b.sendMessage(chatID, chunk.String(), nil)
+			chunk.Reset()
+		}
+		chunk.WriteString(line)
+	}
+	b.sendMessage(chatID, chunk.String(), replyMarkup)
+}
+
+func (b *Bot) sendDocument(chatID int64, fileName string, data []byte) {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: fileName, Bytes: data})
+	if _, err := b.API.Send(doc); err != nil {
+		b.logger.Errorf("Failed to send document: %v", err)
+	}
+}
+
 func (b *Bot) isAdmin(userID int64) bool {
 	return userID == b.config.AdminChatID
 }
@@ -61,6 +101,21 @@ func (b *Bot) clearUserActionData(userID int64) {
 	delete(b.userActionData, userID)
 }
 
+// allowDepositCheck отмечает ручную проверку пополнения и возвращает,
+// сколько ещё нужно подождать, если предыдущая была слишком недавно.
+func (b *Bot) allowDepositCheck(userID int64) (bool, time.Duration) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+
+	if last, ok := b.lastDepositCheck[userID]; ok {
+		if wait := b.config.ManualCheckInterval - time.Since(last); wait > 0 {
+			return false, wait
+		}
+	}
+	b.lastDepositCheck[userID] = time.Now()
+	return true, 0
+}
+
 func (b *Bot) answerCallback(callbackID string, text string) {
 	callback := tgbotapi.NewCallback(callbackID, text)
 	if _, err := b.API.Request(callback); err != nil {
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/pdf"
+)
+
+// Период выписки по умолчанию
+const statementDefaultDays = 30
+
+// Названия видов операций в PDF: встроенные шрифты не содержат кириллицы
+var statementKindLabels = map[string]string{
+	models.LedgerEntryDeposit:    "Deposit",
+	models.LedgerEntryWithdrawal: "Withdrawal",
+	models.LedgerEntryFee:        "Fee",
+	models.LedgerEntryAdjustment: "Adjustment",
+	models.LedgerEntryReversal:   "Reversal",
+	models.LedgerEntryOpening:    "Opening balance",
+}
+
+// handleStatementCommand присылает пользователю выписку в CSV и PDF.
+// Без аргументов берутся последние 30 дней, даты указываются как ГГГГ-ММ-ДД включительно.
+func (b *Bot) handleStatementCommand(ctx context.Context, chatID int64, user *models.User, args []string) {
+	if len(args) > 2 {
+		b.sendMessage(chatID, "Использование: `/statement [с ГГГГ-ММ-ДД] [по ГГГГ-ММ-ДД]`", GetMainMenu(user))
+		return
+	}
+	b.sendStatement(ctx, chatID, user.TelegramID, args)
+}
+
+// handleAdminStatement присылает администратору выписку любого пользователя.
+func (b *Bot) handleAdminStatement(ctx context.Context, chatID int64, userID int64, args []string) {
+	if len(args) > 2 {
+		b.sendMessage(chatID, "Использование: `/statement <telegram_id> [с ГГГГ-ММ-ДД] [по ГГГГ-ММ-ДД]`", nil)
+		return
+	}
+	b.sendStatement(ctx, chatID, userID, args)
+}
+
+func (b *Bot) sendStatement(ctx context.Context, chatID int64, userID int64, args []string) {
+	today := time.Now()
+	to := time.Date(today.Year(), today.Month(), today.Day()+1, 0, 0, 0, 0, time.Local)
+	from, to, err := parsePeriod(args, to.AddDate(0, 0, -statementDefaultDays), to)
+	if err != nil {
+		b.sendMessage(chatID, fmt.Sprintf("❌ %v.", err), nil)
+		return
+	}
+
+	statement, err := b.service.GetStatement(ctx, userID, from, to)
+	if err != nil {
+		b.logger.Errorf("Failed to build statement of user %d: %v", userID, err)
+		b.sendMessage(chatID, "❌ Не удалось сформировать выписку. Попробуйте позже.", nil)
+		return
+	}
+
+	lastDay := statement.To.AddDate(0, 0, -1)
+	b.sendMessage(chatID, fmt.Sprintf(
+		"🧾 Выписка пользователя `%[1]d`\n%[2]s — %[3]s\n\n"+
+			"Остаток на начало: `%[4]s` %[7]s\n"+
+			"Операций: %[5]d\n"+
+			"Остаток на конец: `%[6]s` %[7]s",
+		statement.UserID, statement.From.Format(dateLayout), lastDay.Format(dateLayout),
+		statement.Opening, len(statement.Lines), statement.Closing, statement.Currency,
+	), nil)
+
+	fileName := fmt.Sprintf("statement_%d_%s_%s", statement.UserID, statement.From.Format(dateLayout), lastDay.Format(dateLayout))
+	b.sendDocument(chatID, fileName+".csv", statementCSV(statement))
+	b.sendDocument(chatID, fileName+".pdf", statementPDF(statement))
+}
+
+func statementCSV(statement *models.Statement) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"time", "kind", "reference", "description", "amount", "balance", "currency"})
+	_ = w.Write([]string{
+		statement.From.Format(time.RFC3339), "opening_balance", "", "", "",
+		statement.Opening.String(), statement.Currency,
+	})
+	for _, line := range statement.Lines {
+		_ = w.Write([]string{
+			line.Time.Format(time.RFC3339),
+			line.Kind,
+			line.Reference,
+			line.Description,
+			line.Amount.String(),
+			line.Balance.String(),
+			statement.Currency,
+		})
+	}
+	_ = w.Write([]string{
+		statement.To.Format(time.RFC3339), "closing_balance", "", "", "",
+		statement.Closing.String(), statement.Currency,
+	})
+	w.Flush()
+	return buf.Bytes()
+}
+
+func statementPDF(statement *models.Statement) []byte {
+	const row = "%-16s  %-15s  %-19s  %16s  %16s"
+
+	lines := []string{
+		"ACCOUNT STATEMENT",
+		"",
+		fmt.Sprintf("User:     %d", statement.UserID),
+		fmt.Sprintf("Currency: %s", statement.Currency),
+		fmt.Sprintf("Period:   %s - %s", statement.From.Format(dateLayout), statement.To.AddDate(0, 0, -1).Format(dateLayout)),
+		"",
+		fmt.Sprintf("Opening balance: %s %s", statement.Opening, statement.Currency),
+		"",
+		fmt.Sprintf(row, "Date", "Type", "Reference", "Amount", "Balance"),
+	}
+	for _, line := range statement.Lines {
+		kind, ok := statementKindLabels[line.Kind]
+		if !ok {
+			kind = line.Kind
+		}
+		reference := line.Reference
+		if len(reference) > 19 {
+			reference = reference[:16] + "..."
+		}
+		lines = append(lines, fmt.Sprintf(row,
+			line.Time.Format("2006-01-02 15:04"), kind, reference, line.Amount, line.Balance))
+	}
+	if len(statement.Lines) == 0 {
+		lines = append(lines, "No operations in this period.")
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf("Closing balance: %s %s", statement.Closing, statement.Currency),
+		"",
+		fmt.Sprintf("Generated %s", time.Now().Format("2006-01-02 15:04 MST")),
+	)
+
+	return pdf.Text(lines)
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+)
+
+// Сколько последних заявок показывает пользователю /withdrawals
+const userWithdrawalsLimit = 5
+
+func timelineTime(t time.Time) string {
+	return t.Local().Format("02.01.2006 15:04")
+}
+
+// withdrawalTimelineText описывает, когда заявка создана и проходила статусы, и историю переходов.
+// Администратору видно, кто именно менял статус.
+func withdrawalTimelineText(withdrawal *models.Withdrawal, transitions []models.WithdrawalTransition, forAdmin bool) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🕒 Создана: %s\n", timelineTime(withdrawal.CreatedAt)))
+	for _, step := range []struct {
+		title string
+		at    *time.Time
+	}{
+		{"✅ Одобрена", withdrawal.ApprovedAt},
+		{"📨 Переведена", withdrawal.PaidAt},
+		{"💸 Завершена", withdrawal.CompletedAt},
+		{"❌ Отменена", withdrawal.CanceledAt},
+	} {
+		if step.at != nil {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", step.title, timelineTime(*step.at)))
+		}
+	}
+
+	if len(transitions) == 0 {
+		return sb.String()
+	}
+	sb.WriteString("\n*История статусов:*\n")
+	for _, t := range transitions {
+		from := "создана"
+		if t.FromStatus != "" {
+			from = withdrawalStatusText(t.FromStatus)
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s → %s, %s", t.CreatedAt.Local().Format("02.01 15:04"),
+			from, withdrawalStatusText(t.ToStatus), withdrawalActorText(t, forAdmin)))
+		if t.Comment != "" {
+			sb.WriteString(" (" + escapeMarkdown(t.Comment) + ")")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func withdrawalActorText(t models.WithdrawalTransition, forAdmin bool) string {
+	switch t.Actor {
+	case models.WithdrawalActorUser:
+		if forAdmin {
+			return fmt.Sprintf("пользователь `%d`", t.ActorID)
+		}
+		return "вы"
+	case models.WithdrawalActorAdmin:
+		if forAdmin {
+			return fmt.Sprintf("администратор `%d`", t.ActorID)
+		}
+		return "администратор"
+	default:
+		return "система"
+	}
+}
+
+// handleUserWithdrawals показывает пользователю его последние заявки на вывод с их историей.
+func (b *Bot) handleUserWithdrawals(ctx context.Context, chatID int64, user *models.User) {
+	withdrawals, err := b.service.GetUserWithdrawals(ctx, user.TelegramID, userWithdrawalsLimit)
+	if err != nil {
+		b.logger.Errorf("Failed to get withdrawals of user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось получить заявки. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	if len(withdrawals) == 0 {
+		b.sendMessage(chatID, "У вас ещё не было заявок на вывод.", GetMainMenu(user))
+		return
+	}
+
+	for i := range withdrawals {
+		withdrawal := &withdrawals[i]
+		transitions, err := b.service.GetWithdrawalTransitions(ctx, withdrawal.ID)
+		if err != nil {
+			b.logger.Errorf("Failed to get transitions of withdrawal #%d: %v", withdrawal.ID, err)
+		}
+		text := fmt.Sprintf(
+			"💸 *Заявка #%d*\n💰 `%s` %s, комиссия `%s` %s\n📌 %s\n\n%s",
+			withdrawal.ID, withdrawal.Amount, withdrawal.Currency, withdrawal.Fee, withdrawal.Currency,
+			withdrawalStatusText(withdrawal.Status), withdrawalTimelineText(withdrawal, transitions, false),
+		)
+		b.sendMessage(chatID, text, GetMainMenu(user))
+	}
+}
+
+// handleAdminWithdrawal присылает администратору карточку заявки `/withdrawal <id>` с её историей.
+func (b *Bot) handleAdminWithdrawal(ctx context.Context, chatID int64, args []string) {
+	if len(args) != 1 {
+		b.sendMessage(chatID, "Использование: `/withdrawal <номер заявки>`", nil)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Неверный номер заявки.", nil)
+		return
+	}
+
+	withdrawal, err := b.service.GetWithdrawalByID(ctx, id)
+	if err != nil {
+		b.logger.Errorf("Failed to get withdrawal #%d: %v", id, err)
+		b.sendMessage(chatID, "❌ Не удалось получить заявку.", nil)
+		return
+	}
+	if withdrawal == nil {
+		b.sendMessage(chatID, fmt.Sprintf("Заявка #%d не найдена.", id), nil)
+		return
+	}
+	transitions, err := b.service.GetWithdrawalTransitions(ctx, withdrawal.ID)
+	if err != nil {
+		b.logger.Errorf("Failed to get transitions of withdrawal #%d: %v", id, err)
+	}
+
+	var keyboard interface{}
+	if markup := withdrawalKeyboard(withdrawal); markup != nil {
+		keyboard = markup
+	}
+	text := withdrawalCardText(withdrawal) + "\n\n" + withdrawalTimelineText(withdrawal, transitions, true)
+	b.sendMessage(chatID, text, keyboard)
+}
@@ -2,48 +2,73 @@ package bot
 
 import (
 	"fmt"
+	"time"
+
 	"github.com/Fi44er/btc_bot/internal/models"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/Fi44er/btc_bot/internal/money"
 )
 
 func (b *Bot) notifyAboutTransaction(user *models.User, tx *models.Transaction) {
 	b.logger.Infof("NOTIFY: Callback received for user %d, tx %s. Preparing notifications...", user.TelegramID, tx.TxID)
 
-	rate, err := b.service.GetBTCRUBRate()
-	if err != nil {
-		b.logger.Warnf("Failed to get BTC/RUB rate for notification: %v", err)
-		rate = 4000000.0
+	if tx.Status == models.TransactionStatusHeld {
+		b.notifyAboutHeldTransaction(user, tx)
+		return
 	}
-	rubAmount := tx.AmountBTC * rate
 
 	adminMsgText := fmt.Sprintf(
 		"✅ Новое пополнение!\n\n"+
 			"👤 *Пользователь:* `%d`\n"+
 			"💳 *Карта:* `%s`\n"+
-			"💰 *Сумма:* `%.8f` BTC (`~%.2f` RUB)\n"+
+			"💰 *Сумма:* `%.8f` BTC → `%s` %s\n"+
+			"📈 *Рыночный курс:* `%.2f` %s (%s, %s)\n"+
+			"💱 *Курс зачисления:* `%.2f` %s\n"+
+			"📊 *Спред:* `%.2f%%` (%s), маржа `%s` %s\n"+
 			"🧾 *Адрес:* `%s`\n"+
 			"🔗 *TXID:* `%s`", user.TelegramID,
 		user.CardNumber,
-		tx.AmountBTC,
-		rubAmount,
+		tx.AmountSats.ToBTC(), tx.AmountFiat, tx.Currency,
+		tx.MarketRate, tx.Currency, tx.RateSource, formatRateTime(tx.RateAt),
+		tx.Rate, tx.Currency,
+		tx.Spread*100, tx.PriceTier, money.FromBTC(tx.AmountSats, tx.MarketRate)-tx.AmountFiat, tx.Currency,
 		tx.Address,
 		tx.TxID)
 
-	contactBtn := tgbotapi.NewInlineKeyboardButtonData(
-		"✍️ Связаться с пользователем",
-		fmt.Sprintf("contact_user:%d", user.TelegramID),
-	)
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(contactBtn))
-
 	adminChatID := b.service.GetAdminChatID()
 	b.logger.Infof("NOTIFY: Attempting to send notification to ADMIN with ChatID: %d", adminChatID)
-	b.sendMessage(adminChatID, adminMsgText, keyboard)
+	b.sendMessage(adminChatID, adminMsgText, nil)
 
 	userMsg := fmt.Sprintf(
-		"✅ Ваш баланс пополнен на `%.2f` RUB (из `%.8f` BTC).\n\n"+
-			"Для вывода средств дождитесь, когда с вами свяжется администратор.",
-		rubAmount, tx.AmountBTC,
+		"✅ Ваш баланс пополнен на `%s` %s (из `%.8f` BTC по курсу `%.2f` %s).\n\n"+
+			"Для вывода средств нажмите «💸 Вывести средства» в меню.",
+		tx.AmountFiat, tx.Currency, tx.AmountSats.ToBTC(), tx.Rate, tx.Currency,
 	)
 	b.logger.Infof("NOTIFY: Attempting to send notification to USER with ChatID: %d", user.TelegramID)
 	b.sendMessage(user.TelegramID, userMsg, GetMainMenu(user))
 }
+
+func (b *Bot) notifyAboutHeldTransaction(user *models.User, tx *models.Transaction) {
+	minDepositBTC := b.config.MinDepositSats.ToBTC()
+
+	adminMsgText := fmt.Sprintf(
+		"🟡 Пополнение ниже минимальной суммы отложено.\n\n"+
+			"👤 *Пользователь:* `%d`\n"+
+			"💰 *Сумма:* `%.8f` BTC (минимум `%.8f` BTC)\n"+
+			"🔗 *TXID:* `%s`",
+		user.TelegramID, tx.AmountSats.ToBTC(), minDepositBTC, tx.TxID)
+	b.sendMessage(b.service.GetAdminChatID(), adminMsgText, nil)
+
+	userMsg := fmt.Sprintf(
+		"🟡 Получено `%.8f` BTC, но это меньше минимальной суммы зачисления (`%.8f` BTC).\n\n"+
+			"Средства будут зачислены, когда общая сумма поступлений достигнет минимума.",
+		tx.AmountSats.ToBTC(), minDepositBTC,
+	)
+	b.sendMessage(user.TelegramID, userMsg, GetMainMenu(user))
+}
+
+func formatRateTime(t *time.Time) string {
+	if t == nil {
+		return "—"
+	}
+	return t.Local().Format("02.01.2006 15:04:05")
+}
@@ -2,68 +2,388 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Fi44er/btc_bot/internal/fees"
 	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/internal/service"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// Сколько действует показанный расчёт вывода
+const withdrawPreviewTTL = 10 * time.Minute
+
+// withdrawPreview — расчёт вывода, показанный пользователю. Кнопка подтверждения несёт только его id,
+// а сам расчёт забирается один раз: повторное нажатие или старое сообщение заявку не пополнят.
+type withdrawPreview struct {
+	id        string
+	amount    money.Amount
+	createdAt time.Time
+}
+
+// takeWithdrawPreview забирает расчёт пользователя, если кнопка относится к последнему показанному
+// и он ещё не устарел. Кнопки старых сообщений последний расчёт не трогают.
+func (b *Bot) takeWithdrawPreview(userID int64, id string) *withdrawPreview {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+
+	preview := b.pendingWithdrawals[userID]
+	if preview == nil || preview.id != id {
+		return nil
+	}
+	delete(b.pendingWithdrawals, userID)
+	if time.Since(preview.createdAt) > withdrawPreviewTTL {
+		return nil
+	}
+	return preview
+}
+
 func (b *Bot) handleWithdrawRequest(ctx context.Context, chatID int64, user *models.User) {
 	if user.Balance <= 0 {
 		b.sendMessage(chatID, "❌ На вашем балансе нет средств для вывода.", GetMainMenu(user))
 		return
 	}
-	msg := "Пожалуйста, введите точную сумму в RUB, которую вы получили на карту от оператора."
-	b.setState(user.TelegramID, stateAwaitingWithdrawConfirmationAmount)
+
+	pending, err := b.service.GetPendingWithdrawalByUserID(ctx, user.TelegramID)
+	if err != nil {
+		b.logger.Errorf("Failed to get pending withdrawal of user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось проверить ваши заявки. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	if pending != nil && pending.Status != models.WithdrawalStatusPending {
+		b.sendMessage(chatID, fmt.Sprintf(
+			"⏳ По заявке #%d на `%s` %s уже идёт выплата. Новую заявку можно будет создать после её завершения.",
+			pending.ID, pending.Amount, pending.Currency,
+		), GetMainMenu(user))
+		return
+	}
+
+	limits, err := b.service.GetWithdrawalLimits(ctx, user.TelegramID)
+	if err != nil {
+		b.logger.Errorf("Failed to get withdrawal limits of user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось проверить лимиты на вывод. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	if limits.CooldownUntil != nil {
+		b.sendMessage(chatID, fmt.Sprintf(
+			"⏳ Вы недавно сменили номер карты. В целях безопасности вывод станет доступен %s.",
+			limits.CooldownUntil.Local().Format("02.01.2006 15:04"),
+		), GetMainMenu(user))
+		return
+	}
+
+	msg := fmt.Sprintf("Введите сумму вывода в %s на карту `%s`.\n\nДоступно: `%s` %s\n\n%s",
+		user.Currency, user.CardNumber, user.Balance, user.Currency, withdrawalLimitsText(limits))
+	if pending != nil {
+		msg += fmt.Sprintf("\nСумма будет добавлена к заявке #%d на `%s` %s.", pending.ID, pending.Amount, pending.Currency)
+	}
+	b.setState(user.TelegramID, stateAwaitingWithdrawAmount)
 	b.sendMessage(chatID, msg, tgbotapi.NewRemoveKeyboard(true))
 }
 
-func (b *Bot) handleWithdrawConfirmation(ctx context.Context, chatID int64, user *models.User, text string) {
+// handleWithdrawAmountInput показывает комиссию и итоговое списание и просит подтвердить вывод.
+func (b *Bot) handleWithdrawAmountInput(ctx context.Context, chatID int64, user *models.User, text string) {
 	b.setState(user.TelegramID, stateDefault)
 
-	receivedAmount, err := strconv.ParseFloat(strings.Replace(text, ",", ".", -1), 64)
-	if err != nil || receivedAmount <= 0 {
+	amount, err := money.Parse(text)
+	if err != nil || amount <= 0 {
 		b.sendMessage(chatID, "❌ Неверная сумма. Введите положительное число. Операция отменена.", GetMainMenu(user))
 		return
 	}
 
-	amountToDeduct := (receivedAmount / 1.11) * 1.06
+	preview, err := b.service.PreviewWithdrawal(ctx, user.TelegramID, fees.MethodCard, amount)
+	if errors.Is(err, service.ErrWithdrawalStatus) || errors.Is(err, service.ErrWithdrawalLimit) || errors.Is(err, service.ErrDisputeOpen) {
+		b.sendMessage(chatID, fmt.Sprintf("❌ %v", err), GetMainMenu(user))
+		return
+	}
+	if err != nil {
+		b.logger.Errorf("Failed to preview withdrawal for user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось рассчитать комиссию. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("💸 Вывод на карту `%s`\n\n", preview.CardNumber))
+	if preview.ID != 0 {
+		sb.WriteString(fmt.Sprintf("Сумма будет добавлена к заявке #%d, комиссия считается по итоговой сумме.\n\n", preview.ID))
+	}
+	sb.WriteString(fmt.Sprintf(
+		"К выплате: `%[1]s` %[4]s\n"+
+			"Комиссия: `%[2]s` %[4]s\n"+
+			"Всего спишется: `%[3]s` %[4]s\n\n"+
+			"Подтвердить заявку?",
+		preview.Amount, preview.Fee, preview.Amount+preview.Fee, preview.Currency,
+	))
+
+	previewID, err := newCallbackID()
+	if err != nil {
+		b.logger.Errorf("Failed to generate withdrawal preview ID: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось подготовить заявку. Попробуйте позже.", GetMainMenu(user))
+		return
+	}
+	// Запоминаем добавляемую сумму: комиссия пересчитывается при подтверждении.
+	// Новый расчёт заменяет предыдущий, так что подтвердить можно только последний
+	b.stateMutex.Lock()
+	b.pendingWithdrawals[user.TelegramID] = &withdrawPreview{id: previewID, amount: amount, createdAt: time.Now()}
+	b.stateMutex.Unlock()
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Подтвердить", "withdraw:confirm:"+previewID),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Отменить", "withdraw:cancel:"+previewID),
+	))
+	b.sendMessage(chatID, sb.String(), keyboard)
+}
+
+// handleWithdrawConfirmCallback создаёт заявку после подтверждения пользователем и отправляет её администратору.
+func (b *Bot) handleWithdrawConfirmCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	if _, err := b.API.Send(edit); err != nil {
+		b.logger.Errorf("Failed to remove withdrawal preview keyboard: %v", err)
+	}
+
+	user, err := b.service.GetUser(ctx, callback.From.ID)
+	if err != nil || user == nil {
+		b.logger.Errorf("Failed to get user %d to confirm withdrawal: %v", callback.From.ID, err)
+		b.answerCallback(callback.ID, "❌ Не удалось создать заявку.")
+		return
+	}
 
-	if user.Balance < amountToDeduct {
-		errorMsg := fmt.Sprintf(
-			"❌ Недостаточно средств для списания.\n\n"+
-				"Ваш баланс: `%.2f` RUB\n"+
-				"Требуется для списания: `%.2f` RUB\n\n"+
-				"Операция отменена. Пожалуйста, проверьте введенную сумму или свяжитесь с администратором.",
-			user.Balance, amountToDeduct,
-		)
-		b.sendMessage(chatID, errorMsg, GetMainMenu(user))
+	button, previewID, ok := strings.Cut(strings.TrimPrefix(callback.Data, "withdraw:"), ":")
+	if !ok {
+		b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
 		return
 	}
+	preview := b.takeWithdrawPreview(user.TelegramID, previewID)
 
-	newBalance := user.Balance - amountToDeduct
-	err = b.service.UpdateUserBalance(ctx, user.TelegramID, newBalance)
+	if button == "cancel" {
+		b.answerCallback(callback.ID, "")
+		b.sendMessage(chatID, "Вывод отменён.", GetMainMenu(user))
+		return
+	}
+	if preview == nil {
+		b.answerCallback(callback.ID, "Это подтверждение уже использовано или устарело.")
+		return
+	}
+	b.answerCallback(callback.ID, "")
+
+	withdrawal, updated, err := b.service.CreateOrUpdateWithdrawal(ctx, &models.Withdrawal{
+		UserID:     user.TelegramID,
+		CardNumber: user.CardNumber,
+		Method:     fees.MethodCard,
+		Amount:     preview.amount,
+	})
+	if errors.Is(err, models.ErrInsufficientFunds) || errors.Is(err, service.ErrWithdrawalStatus) ||
+		errors.Is(err, service.ErrWithdrawalLimit) || errors.Is(err, service.ErrDisputeOpen) {
+		b.sendMessage(chatID, fmt.Sprintf("❌ %v", err), GetMainMenu(user))
+		return
+	}
 	if err != nil {
-		b.logger.Errorf("Failed to update user balance on withdraw confirmation: %v", err)
-		b.sendMessage(chatID, "❌ Произошла ошибка при обновлении баланса. Свяжитесь с поддержкой.", GetMainMenu(user))
+		b.logger.Errorf("Failed to create withdrawal for user %d: %v", user.TelegramID, err)
+		b.sendMessage(chatID, "❌ Не удалось создать заявку на вывод. Попробуйте позже.", GetMainMenu(user))
 		return
 	}
 
-	user.Balance = newBalance
+	action := "создана"
+	if updated {
+		action = "обновлена"
+	}
+	b.sendMessage(chatID, fmt.Sprintf(
+		"✅ Заявка #%[1]d %[2]s: к выплате `%[3]s` %[5]s, комиссия `%[4]s` %[5]s.\n\n"+
+			"Сумма с комиссией зарезервирована на балансе до решения администратора.",
+		withdrawal.ID, action, withdrawal.Amount, withdrawal.Fee, withdrawal.Currency,
+	), GetMainMenu(user))
 
-	successMsg := fmt.Sprintf(
-		"✅ Вывод на сумму `%.2f` RUB успешно подтвержден!\n\nВаш новый баланс: `%.2f` RUB.",
-		receivedAmount, newBalance,
-	)
-	b.sendMessage(chatID, successMsg, GetMainMenu(user))
+	b.sendWithdrawalCard(b.service.GetAdminChatID(), withdrawal)
+}
 
-	adminMsg := fmt.Sprintf(
-		"✅ Пользователь `%d` подтвердил получение `%.2f` RUB.\n\n"+
-			"С его баланса списано `%.2f` RUB.\n"+
-			"Новый баланс пользователя: `%.2f` RUB.",
-		user.TelegramID, receivedAmount, amountToDeduct, newBalance,
+// handleWithdrawals присылает администратору карточки всех незавершённых заявок.
+func (b *Bot) handleWithdrawals(ctx context.Context, chatID int64) {
+	withdrawals, err := b.service.GetPendingWithdrawals(ctx)
+	if err != nil {
+		b.logger.Errorf("Failed to get pending withdrawals: %v", err)
+		b.sendMessage(chatID, "❌ Не удалось получить заявки на вывод.", nil)
+		return
+	}
+
+	if len(withdrawals) == 0 {
+		b.sendMessage(chatID, "Незавершённых заявок на вывод нет.", nil)
+		return
+	}
+
+	for _, withdrawal := range withdrawals {
+		b.sendWithdrawalCard(chatID, withdrawal)
+	}
+}
+
+func (b *Bot) sendWithdrawalCard(chatID int64, withdrawal *models.Withdrawal) {
+	var keyboard interface{}
+	if markup := withdrawalKeyboard(withdrawal); markup != nil {
+		keyboard = markup
+	}
+	b.sendMessage(chatID, withdrawalCardText(withdrawal), keyboard)
+}
+
+func withdrawalCardText(withdrawal *models.Withdrawal) string {
+	text := fmt.Sprintf(
+		"💸 Заявка на вывод #%d\n\n"+
+			"👤 *Пользователь:* `%d`\n"+
+			"💳 *Карта:* `%s`\n"+
+			"💰 *К выплате:* `%[4]s` %[6]s\n"+
+			"🧾 *Комиссия:* `%[5]s` %[6]s\n"+
+			"📌 *Статус:* %[7]s",
+		withdrawal.ID, withdrawal.UserID, withdrawal.CardNumber,
+		withdrawal.Amount, withdrawal.Fee, withdrawal.Currency, withdrawalStatusText(withdrawal.Status),
 	)
-	b.sendMessage(b.service.GetAdminChatID(), adminMsg, nil)
+	if withdrawal.PaidAt != nil {
+		text += "\n\n" + payoutText(withdrawal)
+	}
+	return text
+}
+
+// withdrawalKeyboard возвращает действия, доступные администратору в текущем статусе заявки.
+func withdrawalKeyboard(withdrawal *models.Withdrawal) *tgbotapi.InlineKeyboardMarkup {
+	button := func(text, action string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(text, fmt.Sprintf("withdrawal:%s:%d", action, withdrawal.ID))
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	switch withdrawal.Status {
+	case models.WithdrawalStatusPending:
+		row = append(row, button("✅ Одобрить", "approve"), button("❌ Отклонить", "reject"))
+	case models.WithdrawalStatusApproved:
+		row = append(row, button("💸 Записать перевод", "payout"), button("❌ Отклонить", "reject"))
+	case models.WithdrawalStatusDisputed:
+		// Отклонить заявку с записанным переводом можно, только подтвердив, что перевода не было
+		row = append(row, button("💸 Записать перевод заново", "payout"), button("🚫 Перевода не было", "void"))
+	default:
+		return nil
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &keyboard
+}
+
+func withdrawalStatusText(status string) string {
+	switch status {
+	case models.WithdrawalStatusPending:
+		return "⏳ ждёт решения"
+	case models.WithdrawalStatusApproved:
+		return "✅ одобрена, ждёт перевода"
+	case models.WithdrawalStatusPaid:
+		return "📨 переведена, ждёт подтверждения пользователя"
+	case models.WithdrawalStatusDisputed:
+		return "⚠️ перевод оспорен или не совпадает с заявкой"
+	case models.WithdrawalStatusCompleted:
+		return "💸 выплачена"
+	case models.WithdrawalStatusCanceled:
+		return "❌ отклонена"
+	default:
+		return status
+	}
+}
+
+// handleWithdrawalCallback выполняет действие администратора по заявке, обновляет её карточку
+// и сообщает пользователю о новом статусе.
+func (b *Bot) handleWithdrawalCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "withdrawal:"), ":")
+	if len(parts) != 2 {
+		b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		b.answerCallback(callback.ID, "Ошибка: неверные данные кнопки.")
+		return
+	}
+
+	// Перевод записывается вводом его данных, а не одной кнопкой
+	if parts[0] == "payout" {
+		b.handlePayoutRequest(callback, id)
+		return
+	}
+
+	statuses := map[string]string{
+		"approve": models.WithdrawalStatusApproved,
+		"reject":  models.WithdrawalStatusCanceled,
+		"void":    models.WithdrawalStatusCanceled,
+	}
+	status, ok := statuses[parts[0]]
+	if !ok {
+		b.answerCallback(callback.ID, "Ошибка: неизвестное действие.")
+		return
+	}
+
+	if parts[0] == "void" {
+		err = b.service.VoidPayout(ctx, callback.From.ID, id)
+	} else {
+		err = b.service.UpdateWithdrawalStatus(ctx, callback.From.ID, id, status)
+	}
+	// Повторное нажатие лишь обновляет карточку: пользователь уже получил уведомление
+	moved := err == nil
+	switch {
+	case errors.Is(err, service.ErrWithdrawalStatus):
+		b.answerCallback(callback.ID, "Заявка уже обработана.")
+	case errors.Is(err, service.ErrDisputeOpen):
+		b.answerCallback(callback.ID, "По заявке открыт спор, она заморожена до его решения.")
+		return
+	case err != nil:
+		b.logger.Errorf("Failed to move withdrawal #%d to %s: %v", id, status, err)
+		b.answerCallback(callback.ID, "❌ Не удалось обновить заявку.")
+		return
+	default:
+		b.answerCallback(callback.ID, "")
+	}
+
+	withdrawal, err := b.service.GetWithdrawalByID(ctx, id)
+	if err != nil || withdrawal == nil {
+		b.logger.Errorf("Failed to reload withdrawal #%d: %v", id, err)
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, withdrawalCardText(withdrawal))
+	edit.ParseMode = tgbotapi.ModeMarkdown
+	edit.ReplyMarkup = withdrawalKeyboard(withdrawal)
+	if _, err := b.API.Send(edit); err != nil {
+		b.logger.Errorf("Failed to edit withdrawal card: %v", err)
+	}
+
+	if moved && withdrawal.Status == status {
+		b.notifyAboutWithdrawal(ctx, withdrawal)
+	}
+}
+
+func (b *Bot) notifyAboutWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) {
+	var msg string
+	switch withdrawal.Status {
+	case models.WithdrawalStatusPaid:
+		b.notifyAboutPayout(withdrawal)
+		return
+	case models.WithdrawalStatusApproved:
+		msg = fmt.Sprintf("✅ Заявка #%d на вывод `%s` %s одобрена. Ожидайте перевода на карту `%s`.",
+			withdrawal.ID, withdrawal.Amount, withdrawal.Currency, withdrawal.CardNumber)
+	case models.WithdrawalStatusCompleted:
+		msg = fmt.Sprintf("✅ Вывод по заявке #%d завершён: на карту `%s` переведено `%s` %s, комиссия `%s` %s.",
+			withdrawal.ID, withdrawal.CardNumber, withdrawal.Amount, withdrawal.Currency, withdrawal.Fee, withdrawal.Currency)
+	case models.WithdrawalStatusCanceled:
+		msg = fmt.Sprintf("❌ Заявка #%d на вывод `%s` %s отклонена. Сумма возвращена на баланс.",
+			withdrawal.ID, withdrawal.Amount, withdrawal.Currency)
+	default:
+		return
+	}
+
+	user, err := b.service.GetUser(ctx, withdrawal.UserID)
+	if err != nil || user == nil {
+		b.logger.Errorf("Failed to get user %d to notify about withdrawal #%d: %v", withdrawal.UserID, withdrawal.ID, err)
+		b.sendMessage(withdrawal.UserID, msg, nil)
+		return
+	}
+	b.sendMessage(user.TelegramID, msg, GetMainMenu(user))
 }
@@ -0,0 +1,28 @@
+package chain
+
+import (
+	"context"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// Output — выход транзакции на отслеживаемый адрес.
+type Output struct {
+	TxID        string
+	Vout        uint32
+	Address     string
+	Value       btcutil.Amount
+	Confirmed   bool
+	BlockHeight int64
+}
+
+// Backend предоставляет доступ к данным блокчейна.
+type Backend interface {
+	// AddressOutputs возвращает выходы на адрес из последних транзакций.
+	AddressOutputs(ctx context.Context, address string) ([]Output, error)
+	// AddressHistory возвращает выходы на адрес за всю историю начиная с блока fromHeight,
+	// включая ещё не подтверждённые.
+	AddressHistory(ctx context.Context, address string, fromHeight int64) ([]Output, error)
+	// TipHeight возвращает высоту последнего блока.
+	TipHeight(ctx context.Context) (int64, error)
+}
@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// Mempool работает через REST API mempool.space (или совместимого esplora-сервера).
+type Mempool struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewMempool(baseURL string) *Mempool {
+	return &Mempool{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type mempoolTx struct {
+	TxID string `json:"txid"`
+	Vout []struct {
+		Address string `json:"scriptpubkey_address"`
+		Value   int64  `json:"value"`
+	} `json:"vout"`
+	Status struct {
+		Confirmed   bool  `json:"confirmed"`
+		BlockHeight int64 `json:"block_height"`
+	} `json:"status"`
+}
+
+func (m *Mempool) AddressOutputs(ctx context.Context, address string) ([]Output, error) {
+	txs, err := m.addressTxs(ctx, fmt.Sprintf("/address/%s/txs", address))
+	if err != nil {
+		return nil, err
+	}
+	return outputsTo(address, txs, 0), nil
+}
+
+func (m *Mempool) AddressHistory(ctx context.Context, address string, fromHeight int64) ([]Output, error) {
+	// Первая страница содержит неподтверждённые и последние подтверждённые транзакции,
+	// следующие запрашиваются по txid последней подтверждённой.
+	txs, err := m.addressTxs(ctx, fmt.Sprintf("/address/%s/txs", address))
+	if err != nil {
+		return nil, err
+	}
+	outputs := outputsTo(address, txs, fromHeight)
+
+	for {
+		lastSeen := ""
+		for _, tx := range txs {
+			if tx.Status.Confirmed {
+				lastSeen = tx.TxID
+			}
+		}
+		if lastSeen == "" || txs[len(txs)-1].Status.BlockHeight < fromHeight {
+			break
+		}
+
+		txs, err = m.addressTxs(ctx, fmt.Sprintf("/address/%s/txs/chain/%s", address, lastSeen))
+		if err != nil {
+			return nil, err
+		}
+		if len(txs) == 0 {
+			break
+		}
+		outputs = append(outputs, outputsTo(address, txs, fromHeight)...)
+	}
+
+	return outputs, nil
+}
+
+func (m *Mempool) TipHeight(ctx context.Context) (int64, error) {
+	body, err := m.get(ctx, "/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+
+	height, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tip height %q: %v", string(body), err)
+	}
+	return height, nil
+}
+
+func (m *Mempool) addressTxs(ctx context.Context, path string) ([]mempoolTx, error) {
+	body, err := m.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []mempoolTx
+	if err := json.Unmarshal(body, &txs); err != nil {
+		return nil, fmt.Errorf("invalid API response format: %v", err)
+	}
+	return txs, nil
+}
+
+func (m *Mempool) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %v", err)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+// outputsTo выбирает выходы на адрес, отбрасывая подтверждённые ниже fromHeight.
+func outputsTo(address string, txs []mempoolTx, fromHeight int64) []Output {
+	var outputs []Output
+	for _, tx := range txs {
+		if tx.Status.Confirmed && tx.Status.BlockHeight < fromHeight {
+			continue
+		}
+		for i, out := range tx.Vout {
+			if out.Address != address || out.Value <= 0 {
+				continue
+			}
+			outputs = append(outputs, Output{
+				TxID:        tx.TxID,
+				Vout:        uint32(i),
+				Address:     address,
+				Value:       btcutil.Amount(out.Value),
+				Confirmed:   tx.Status.Confirmed,
+				BlockHeight: tx.Status.BlockHeight,
+			})
+		}
+	}
+	return outputs
+}
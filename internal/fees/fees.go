@@ -0,0 +1,215 @@
+package fees
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+// MethodCard — перевод на банковскую карту, способ выплаты по умолчанию.
+const MethodCard = "card"
+
+// Tier — комиссия за вывод суммы не меньше MinAmount (в валюте расписания).
+type Tier struct {
+	MinAmount money.Amount
+	Percent   float64 // процент от суммы вывода
+	Fixed     money.Amount
+}
+
+// Schedule — комиссии одного способа выплаты в одной валюте.
+type Schedule struct {
+	Tiers []Tier // по возрастанию MinAmount
+	Min   money.Amount
+}
+
+// hasAmounts сообщает, есть ли в расписании суммы, а не только проценты.
+func (s Schedule) hasAmounts() bool {
+	for _, tier := range s.Tiers {
+		if tier.MinAmount != 0 || tier.Fixed != 0 {
+			return true
+		}
+	}
+	return s.Min != 0
+}
+
+// anyCurrency — ключ расписания способа выплаты для валют без собственного расписания.
+const anyCurrency = ""
+
+// Fees определяет, какую комиссию удерживать с выводов.
+type Fees struct {
+	schedules map[string]map[string]Schedule // способ выплаты → валюта → расписание
+}
+
+// New принимает уровни комиссий по способам выплаты в формате
+// "способ/валюта=сумма:процент:фикс,сумма:процент:фикс;способ=процент..." и минимальные комиссии
+// в формате "способ/валюта=сумма,способ/валюта=сумма". Суммы в разных валютах — разные деньги,
+// поэтому расписание без валюты действует для остальных валют и может содержать только проценты.
+func New(schedules, minimums string, currencies []string) (*Fees, error) {
+	f := &Fees{schedules: make(map[string]map[string]Schedule)}
+
+	for _, part := range strings.Split(schedules, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, tiersStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fee schedule %q, expected method/currency=tiers", part)
+		}
+		method, currency, err := parseKey(key, currencies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fee schedule %q: %w", part, err)
+		}
+		if _, dup := f.schedules[method][currency]; dup {
+			return nil, fmt.Errorf("fee schedule %s is set twice", strings.TrimSpace(key))
+		}
+
+		tiers, err := ParseTiers(tiersStr)
+		if err != nil {
+			return nil, fmt.Errorf("fee schedule %s: %w", strings.TrimSpace(key), err)
+		}
+		schedule := Schedule{Tiers: tiers}
+		if currency == anyCurrency && schedule.hasAmounts() {
+			return nil, fmt.Errorf("fee schedule %s has amounts without a currency, use %s/CUR=...", method, method)
+		}
+		if f.schedules[method] == nil {
+			f.schedules[method] = make(map[string]Schedule)
+		}
+		f.schedules[method][currency] = schedule
+	}
+
+	if len(f.schedules) == 0 {
+		return nil, fmt.Errorf("no withdrawal fee schedules configured")
+	}
+
+	for _, part := range strings.Split(minimums, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, amountStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid minimum fee %q, expected method/currency=amount", part)
+		}
+		method, currency, err := parseKey(key, currencies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum fee %q: %w", part, err)
+		}
+		if currency == anyCurrency {
+			return nil, fmt.Errorf("minimum fee %q has no currency, expected %s/CUR=amount", part, method)
+		}
+		byCurrency, known := f.schedules[method]
+		if !known {
+			return nil, fmt.Errorf("minimum fee for unknown payout method %q", method)
+		}
+
+		minFee, err := money.Parse(strings.TrimSpace(amountStr))
+		if err != nil || minFee < 0 {
+			return nil, fmt.Errorf("invalid minimum fee %q", amountStr)
+		}
+		// Минимум для валюты без своего расписания дополняет общее процентное
+		schedule, ok := byCurrency[currency]
+		if !ok {
+			if schedule, ok = byCurrency[anyCurrency]; !ok {
+				return nil, fmt.Errorf("minimum fee for %s without %s fee schedule", currency, method)
+			}
+		}
+		schedule.Min = minFee
+		byCurrency[currency] = schedule
+	}
+
+	return f, nil
+}
+
+// parseKey разбирает ключ расписания "способ" или "способ/валюта".
+func parseKey(key string, currencies []string) (method, currency string, err error) {
+	method, currency, _ = strings.Cut(key, "/")
+	method = strings.TrimSpace(method)
+	if method == "" {
+		return "", "", fmt.Errorf("no payout method")
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency != anyCurrency && !slices.Contains(currencies, currency) {
+		return "", "", fmt.Errorf("unsupported currency %q", currency)
+	}
+	return method, currency, nil
+}
+
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid fee tier %q, expected amount:percent:fixed", part)
+		}
+
+		minAmount, err := money.Parse(strings.TrimSpace(fields[0]))
+		if err != nil || minAmount < 0 {
+			return nil, fmt.Errorf("invalid tier amount %q", fields[0])
+		}
+
+		percent, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil || percent < 0 || percent >= 100 {
+			return nil, fmt.Errorf("invalid tier percent %q", fields[1])
+		}
+
+		fixed, err := money.Parse(strings.TrimSpace(fields[2]))
+		if err != nil || fixed < 0 {
+			return nil, fmt.Errorf("invalid tier fixed fee %q", fields[2])
+		}
+
+		tiers = append(tiers, Tier{MinAmount: minAmount, Percent: percent, Fixed: fixed})
+	}
+
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("no fee tiers")
+	}
+
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinAmount < tiers[j].MinAmount })
+	return tiers, nil
+}
+
+// Methods возвращает настроенные способы выплаты.
+func (f *Fees) Methods() []string {
+	methods := make([]string, 0, len(f.schedules))
+	for method := range f.schedules {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Fee считает комиссию за вывод amount в валюте currency способом method: процент и фиксированная часть
+// уровня с наибольшим порогом, не превышающим amount, но не меньше минимальной комиссии.
+func (f *Fees) Fee(method, currency string, amount money.Amount) (money.Amount, error) {
+	byCurrency, ok := f.schedules[method]
+	if !ok {
+		return 0, fmt.Errorf("unknown payout method %q", method)
+	}
+	schedule, ok := byCurrency[currency]
+	if !ok {
+		if schedule, ok = byCurrency[anyCurrency]; !ok {
+			return 0, fmt.Errorf("no %s fee schedule for %s", method, currency)
+		}
+	}
+
+	var fee money.Amount
+	for _, tier := range schedule.Tiers {
+		if amount >= tier.MinAmount {
+			fee = amount.Mul(tier.Percent/100) + tier.Fixed
+		}
+	}
+
+	return max(fee, schedule.Min), nil
+}
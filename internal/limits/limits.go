@@ -0,0 +1,200 @@
+package limits
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+// Period — скользящее окно, за которое считается сумма выводов.
+type Period struct {
+	Name     string // как период задаётся в настройках
+	Duration time.Duration
+	Title    string // для сообщений пользователю: «суточный лимит»
+}
+
+// Periods — поддерживаемые периоды лимитов, от коротких к длинным.
+var Periods = []Period{
+	{Name: "day", Duration: 24 * time.Hour, Title: "суточный"},
+	{Name: "week", Duration: 7 * 24 * time.Hour, Title: "недельный"},
+	{Name: "month", Duration: 30 * 24 * time.Hour, Title: "месячный"},
+}
+
+// Caps — лимиты на сумму выводов по периодам. Период без лимита или с нулём не ограничен.
+type Caps map[string]money.Amount
+
+// Set — ограничения на вывод в одной валюте. Нулевые значения не ограничивают.
+type Set struct {
+	Min    money.Amount // минимальная сумма заявки
+	Max    money.Amount // максимальная сумма заявки
+	User   Caps         // лимиты одного пользователя
+	Global Caps         // лимиты всех пользователей вместе в этой валюте
+}
+
+// Limits — ограничения на вывод. Суммы задаются отдельно для каждой валюты:
+// одно и то же число в рублях и долларах — разные деньги.
+type Limits struct {
+	sets map[string]Set
+	// Сколько после смены номера карты вывод недоступен
+	CardCooldown time.Duration
+}
+
+// New принимает суммы заявки в формате "RUB=500,USD=10", лимиты пользователя и общие лимиты
+// в формате "RUB:day=сумма,week=сумма,month=сумма;USD:day=сумма", паузу после смены карты
+// и валюты, для которых можно задавать лимиты.
+func New(minAmount, maxAmount, user, global string, cardCooldown time.Duration, currencies []string) (*Limits, error) {
+	l := &Limits{sets: make(map[string]Set), CardCooldown: cardCooldown}
+
+	minimums, err := parseAmounts(minAmount, currencies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum withdrawal: %w", err)
+	}
+	maximums, err := parseAmounts(maxAmount, currencies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maximum withdrawal: %w", err)
+	}
+	userCaps, err := parseCurrencyCaps(user, currencies)
+	if err != nil {
+		return nil, fmt.Errorf("user withdrawal limits: %w", err)
+	}
+	globalCaps, err := parseCurrencyCaps(global, currencies)
+	if err != nil {
+		return nil, fmt.Errorf("global withdrawal limits: %w", err)
+	}
+	if cardCooldown < 0 {
+		return nil, fmt.Errorf("negative card change cooldown %s", cardCooldown)
+	}
+
+	for _, currency := range currencies {
+		set := Set{Min: minimums[currency], Max: maximums[currency], User: userCaps[currency], Global: globalCaps[currency]}
+		if set.Max != 0 && set.Max < set.Min {
+			return nil, fmt.Errorf("maximum withdrawal %s %s is less than minimum %s", set.Max, currency, set.Min)
+		}
+		l.sets[currency] = set
+	}
+
+	return l, nil
+}
+
+// For возвращает ограничения в валюте currency. Для валюты без настроек вывод не ограничен.
+func (l *Limits) For(currency string) Set {
+	return l.sets[currency]
+}
+
+// parseAmounts разбирает суммы по валютам в формате "RUB=500,USD=10".
+func parseAmounts(s string, currencies []string) (map[string]money.Amount, error) {
+	amounts := make(map[string]money.Amount)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		currency, amountStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("amount %q has no currency, expected CUR=amount", part)
+		}
+		currency, err := parseCurrency(currency, currencies)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := amounts[currency]; dup {
+			return nil, fmt.Errorf("amount for %s is set twice", currency)
+		}
+
+		if amounts[currency], err = parseAmount(amountStr); err != nil {
+			return nil, fmt.Errorf("invalid amount %q: %w", part, err)
+		}
+	}
+	return amounts, nil
+}
+
+// parseCurrencyCaps разбирает лимиты по валютам в формате "RUB:day=50000,week=200000;USD:day=600".
+func parseCurrencyCaps(s string, currencies []string) (map[string]Caps, error) {
+	byCurrency := make(map[string]Caps)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		currency, capsStr, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("limits %q have no currency, expected CUR:period=amount", part)
+		}
+		currency, err := parseCurrency(currency, currencies)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := byCurrency[currency]; dup {
+			return nil, fmt.Errorf("limits for %s are set twice", currency)
+		}
+
+		if byCurrency[currency], err = ParseCaps(capsStr); err != nil {
+			return nil, fmt.Errorf("%s: %w", currency, err)
+		}
+	}
+	return byCurrency, nil
+}
+
+func parseCurrency(s string, currencies []string) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(s))
+	if !slices.Contains(currencies, currency) {
+		return "", fmt.Errorf("unsupported currency %q", s)
+	}
+	return currency, nil
+}
+
+func ParseCaps(s string) (Caps, error) {
+	caps := make(Caps)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, amountStr, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q, expected period=amount", part)
+		}
+		if _, known := FindPeriod(name); !known {
+			return nil, fmt.Errorf("unknown limit period %q, expected day, week or month", name)
+		}
+
+		amount, err := parseAmount(amountStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit %q: %w", part, err)
+		}
+		caps[name] = amount
+	}
+	return caps, nil
+}
+
+// FindPeriod ищет период по имени из настроек.
+func FindPeriod(name string) (Period, bool) {
+	for _, period := range Periods {
+		if period.Name == name {
+			return period, true
+		}
+	}
+	return Period{}, false
+}
+
+func parseAmount(s string) (money.Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	amount, err := money.Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	if amount < 0 {
+		return 0, fmt.Errorf("negative amount %s", s)
+	}
+	return amount, nil
+}
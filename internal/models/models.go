@@ -1,9 +1,30 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+var (
+	// ErrInsufficientFunds — списание больше остатка на балансе пользователя.
+	ErrInsufficientFunds = errors.New("недостаточно средств")
+	// ErrAlreadyPosted — запись журнала с таким ключом уже проведена.
+	ErrAlreadyPosted = errors.New("запись журнала уже проведена")
+)
+
 type User struct {
-	TelegramID int64   `gorm:"primaryKey" json:"telegram_id"`
-	CardNumber string  `json:"card_number"`
-	Balance    float64 `gorm:"default:0" json:"balance"`
+	TelegramID int64        `gorm:"primaryKey" json:"telegram_id"`
+	CardNumber string       `json:"card_number"`
+	Balance    money.Amount `gorm:"default:0" json:"balance"`           // в минимальных единицах валюты
+	Currency   string       `gorm:"size:3;default:RUB" json:"currency"` // валюта баланса
+	IsVIP      bool         `gorm:"default:false" json:"is_vip"`
+	// Когда пользователь последний раз сменил номер карты; после смены вывод недоступен на время паузы
+	CardChangedAt *time.Time `json:"card_changed_at"`
 
 	SystemWalletID *int64        `json:"system_wallet_id" gorm:"index"`
 	SystemWallet   *SystemWallet `gorm:"foreignKey:SystemWalletID" json:"system_wallet,omitempty"`
@@ -13,21 +34,163 @@ type User struct {
 	IsAdmin      bool          `json:"is_admin" gorm:"-"`
 }
 
+// Статусы заявки на вывод
+const (
+	WithdrawalStatusPending   = "pending"   // ждёт решения администратора, сумма в резерве
+	WithdrawalStatusApproved  = "approved"  // одобрена, ждёт перевода на карту
+	WithdrawalStatusPaid      = "paid"      // перевод записан администратором, ждёт подтверждения пользователем
+	WithdrawalStatusDisputed  = "disputed"  // пользователь оспорил перевод или записан перевод не на сумму заявки
+	WithdrawalStatusCompleted = "completed" // получение подтверждено, резерв списан
+	WithdrawalStatusCanceled  = "canceled"  // отклонена или отменена, резерв возвращён
+)
+
+// WithdrawalOpenStatuses — статусы незавершённой заявки. Такая заявка у пользователя может быть только одна.
+var WithdrawalOpenStatuses = []string{
+	WithdrawalStatusPending, WithdrawalStatusApproved, WithdrawalStatusPaid, WithdrawalStatusDisputed,
+}
+
+// WithdrawalTransitions — допустимые переходы между статусами заявки на вывод.
+// Из завершённой и отменённой заявки переходов нет. Заявку с записанным переводом обычной отменой
+// не отменить: её резерв вернулся бы на баланс, хотя деньги уже ушли, — см. WithdrawalVoidableStatuses.
+var WithdrawalTransitions = map[string][]string{
+	WithdrawalStatusPending:  {WithdrawalStatusApproved, WithdrawalStatusCanceled},
+	WithdrawalStatusApproved: {WithdrawalStatusPaid, WithdrawalStatusDisputed, WithdrawalStatusCanceled},
+	WithdrawalStatusPaid:     {WithdrawalStatusCompleted, WithdrawalStatusDisputed},
+	WithdrawalStatusDisputed: {WithdrawalStatusPaid, WithdrawalStatusDisputed},
+}
+
+// WithdrawalVoidableStatuses — статусы с записанным переводом, из которых заявку можно отменить,
+// только подтвердив, что перевода на самом деле не было.
+var WithdrawalVoidableStatuses = []string{WithdrawalStatusPaid, WithdrawalStatusDisputed}
+
+// CanMoveWithdrawal сообщает, может ли заявка перейти из статуса from в статус to.
+func CanMoveWithdrawal(from, to string) bool {
+	return slices.Contains(WithdrawalTransitions[from], to)
+}
+
 type Withdrawal struct {
-	ID         uint    `gorm:"primaryKey" json:"id"`
-	UserID     int64   `json:"user_id" gorm:"uniqueIndex:idx_user_pending"`
-	CardNumber string  `json:"card_number"`
-	Amount     float64 `json:"amount"`
-	Status     string  `json:"status" gorm:"default:pending"`
-	CreatedAt  string  `json:"created_at"`
+	ID         uint         `gorm:"primaryKey" json:"id"`
+	UserID     int64        `json:"user_id" gorm:"uniqueIndex:idx_withdrawals_user_active,where:status NOT IN ('completed','canceled')"`
+	CardNumber string       `json:"card_number"`
+	Method     string       `gorm:"size:16;not null;default:card" json:"method"` // способ выплаты
+	Amount     money.Amount `json:"amount"`                                      // сумма к выплате пользователю
+	Fee        money.Amount `gorm:"not null;default:0" json:"fee"`               // комиссия сверх суммы выплаты
+	Currency   string       `gorm:"size:3;default:RUB" json:"currency"`
+	Status     string       `json:"status" gorm:"default:pending"`
+
+	// Когда заявка создана и когда перешла в статусы; подробная история — в WithdrawalTransition
+	CreatedAt   time.Time  `json:"created_at"`
+	ApprovedAt  *time.Time `json:"approved_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CanceledAt  *time.Time `json:"canceled_at"`
+
+	// Перевод, записанный администратором
+	PayoutAmount    money.Amount `gorm:"not null;default:0" json:"payout_amount"`
+	PayoutReference string       `json:"payout_reference"` // номер операции в банке
+	PaidAt          *time.Time   `json:"paid_at"`          // время перевода по данным администратора
 }
 
+// Кто сменил статус заявки
+const (
+	WithdrawalActorUser   = "user"
+	WithdrawalActorAdmin  = "admin"
+	WithdrawalActorSystem = "system"
+)
+
+// WithdrawalTransition — смена статуса заявки на вывод: кто, когда и из какого статуса в какой.
+// У созданной заявки FromStatus пустой.
+type WithdrawalTransition struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	WithdrawalID uint      `gorm:"index" json:"withdrawal_id"`
+	FromStatus   string    `json:"from_status"`
+	ToStatus     string    `json:"to_status"`
+	Actor        string    `json:"actor"`    // WithdrawalActorUser, WithdrawalActorAdmin или WithdrawalActorSystem
+	ActorID      int64     `json:"actor_id"` // telegram ID; 0 для системы
+	Comment      string    `json:"comment"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Статусы пополнения
+const (
+	TransactionStatusPending    = "pending"    // в мемпуле, подтверждений нет
+	TransactionStatusConfirming = "confirming" // в блоке, но подтверждений меньше порога
+	TransactionStatusCredited   = "credited"   // зачислено на баланс
+	TransactionStatusHeld       = "held"       // меньше минимальной суммы, ждёт накопления
+	TransactionStatusIgnored    = "ignored"    // пыль, не зачисляется
+)
+
+// TransactionLegacyVout — номер выхода у пополнений, учтённых до перехода на учёт по выходам:
+// такая запись покрывает все выходы транзакции на адрес.
+const TransactionLegacyVout = -1
+
+// Transaction — пополнение одним выходом транзакции (outpoint) на адрес пользователя.
 type Transaction struct {
-	TxID      string  `gorm:"primaryKey" json:"tx_id"`
-	UserID    int64   `json:"user_id"`
-	Address   string  `json:"address"`
-	AmountBTC float64 `json:"amount_btc"`
-	Confirmed bool    `json:"confirmed"`
+	TxID          string         `gorm:"primaryKey" json:"tx_id"`
+	Vout          int64          `gorm:"primaryKey;autoIncrement:false" json:"vout"`
+	UserID        int64          `json:"user_id" gorm:"index"`
+	Address       string         `json:"address"`
+	AmountSats    btcutil.Amount `json:"amount_sats"`
+	Confirmed     bool           `json:"confirmed"`
+	Confirmations int64          `json:"confirmations"`
+	Status        string         `json:"status" gorm:"default:pending;index"`
+	CreatedAt     time.Time      `json:"created_at"`
+
+	// Условия зачисления, по которым пополнение попало на баланс
+	MarketRate float64      `json:"market_rate"` // рыночный курс BTC к валюте зачисления
+	Rate       float64      `json:"rate"`        // курс с учётом спреда
+	RateSource string       `json:"rate_source"` // источники курса
+	RateAt     *time.Time   `json:"rate_at"`     // время получения курса
+	Spread     float64      `json:"spread"`      // удержанный спред, доля от рыночного курса
+	PriceTier  string       `json:"price_tier"`  // уровень, по которому выбран спред
+	AmountFiat money.Amount `json:"amount_fiat"` // сумма, зачисленная на баланс
+	Currency   string       `gorm:"size:3" json:"currency"`
+	QuoteID    *uint        `json:"quote_id"` // котировка, по которой зачислено
+	CreditedAt *time.Time   `json:"credited_at"`
+}
+
+// Outpoint возвращает идентификатор выхода в виде txid:vout.
+func (t *Transaction) Outpoint() string {
+	return fmt.Sprintf("%s:%d", t.TxID, t.Vout)
+}
+
+// Статусы котировки
+const (
+	QuoteStatusActive   = "active"
+	QuoteStatusUsed     = "used"
+	QuoteStatusCanceled = "canceled"
+)
+
+// Quote — зафиксированный для пользователя курс пополнения на заданную сумму в валюте баланса.
+type Quote struct {
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	UserID     int64          `gorm:"index" json:"user_id"`
+	Address    string         `json:"address"`
+	AmountFiat money.Amount   `json:"amount_fiat"`
+	Currency   string         `gorm:"size:3;default:RUB" json:"currency"`
+	AmountSats btcutil.Amount `json:"amount_sats"`
+	MarketRate float64        `json:"market_rate"`
+	Rate       float64        `json:"rate"`
+	RateSource string         `json:"rate_source"`
+	Spread     float64        `json:"spread"`
+	Status     string         `gorm:"default:active;index" json:"status"`
+	TxID       string         `json:"tx_id"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// ExchangeRate — успешно полученный курс BTC к валюте.
+type ExchangeRate struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Currency  string    `gorm:"size:3;default:RUB;uniqueIndex:idx_exchange_rates_currency_time" json:"currency"`
+	Value     float64   `json:"value"`
+	Source    string    `json:"source"`
+	FetchedAt time.Time `gorm:"uniqueIndex:idx_exchange_rates_currency_time" json:"fetched_at"`
+}
+
+// RateSummary — текущий курс и курс суточной давности.
+type RateSummary struct {
+	Current ExchangeRate
+	DayAgo  *ExchangeRate
 }
 
 type SystemWallet struct {
@@ -37,3 +200,253 @@ type SystemWallet struct {
 }
 
 type NotifyCallback func(*User, *Transaction)
+
+// RescanReport — результат пересканирования адреса по запросу администратора.
+type RescanReport struct {
+	UserID     int64
+	Address    string
+	FromHeight int64
+	Deposits   []RescanDeposit
+	Credited   money.Amount // зачислено в валюте пользователя
+	Currency   string
+}
+
+// HistoryItem — операция в истории пользователя: пополнение или изменение баланса по журналу.
+// Kind — вид записи журнала (LedgerEntryDeposit, LedgerEntryWithdrawal и т. д.).
+type HistoryItem struct {
+	Kind        string
+	Time        time.Time // нулевое для ещё не обработанной заявки на вывод
+	AmountSats  btcutil.Amount
+	Rate        float64
+	Amount      money.Amount // изменение баланса, со знаком
+	Currency    string
+	Status      string // статус пополнения или заявки; пустой для проведённых записей журнала
+	TxID        string
+	Description string
+}
+
+type HistoryPage struct {
+	Items []HistoryItem
+	Page  int // с нуля
+	Pages int
+}
+
+// Statement — выписка по балансу пользователя за период [From, To).
+type Statement struct {
+	UserID   int64
+	Currency string
+	From     time.Time
+	To       time.Time
+	Opening  money.Amount
+	Closing  money.Amount
+	Lines    []StatementLine
+}
+
+type StatementLine struct {
+	Time        time.Time
+	Kind        string // вид записи журнала
+	Reference   string // txid пополнения или номер заявки на вывод
+	Description string
+	Amount      money.Amount
+	Balance     money.Amount // остаток после операции
+}
+
+// Виды расхождений, найденных сверкой
+const (
+	ReconcileMissingDeposit    = "missing_deposit"    // выход есть в сети, но не записан
+	ReconcileUncreditedDeposit = "uncredited_deposit" // подтверждён, но не зачислен
+	ReconcileUnknownRow        = "unknown_row"        // запись о пополнении без выхода в сети
+	ReconcileUnpostedCredit    = "unposted_credit"    // зачислено без записи в журнале
+	ReconcileBalanceDrift      = "balance_drift"      // баланс не совпадает с журналом
+	ReconcileNegativeBalance   = "negative_balance"   // отрицательный баланс
+	ReconcileChainError        = "chain_error"        // не удалось получить данные из сети
+)
+
+// ReconcileReport — результат сверки пополнений в сети с записями, журналом и балансами.
+type ReconcileReport struct {
+	StartedAt  time.Time
+	Users      int
+	ChainSats  btcutil.Amount // подтверждено в сети на адресах пользователей
+	StoredSats btcutil.Amount // учтено в записях о пополнениях
+	Issues     []ReconcileIssue
+}
+
+type ReconcileIssue struct {
+	UserID int64
+	Kind   string
+	Detail string
+}
+
+type RescanDeposit struct {
+	TxID           string
+	Vout           int64
+	AmountSats     btcutil.Amount
+	BlockHeight    int64
+	PreviousStatus string // пустой, если транзакция раньше не встречалась
+	Status         string
+}
+
+// Виды записей журнала
+const (
+	LedgerEntryDeposit    = "deposit"    // зачисление пополнения
+	LedgerEntryWithdrawal = "withdrawal" // списание выплаты на карту
+	LedgerEntryFee        = "fee"        // комиссия
+	LedgerEntryAdjustment = "adjustment" // ручная корректировка администратором
+	LedgerEntryReversal   = "reversal"   // сторно ранее сделанной записи
+	LedgerEntryOpening    = "opening"    // начальный остаток при переходе на журнал
+	LedgerEntryHold       = "hold"       // резерв суммы заявки на вывод
+	LedgerEntryRelease    = "release"    // снятие резерва при отмене заявки
+)
+
+// Счета журнала. Баланс пользователя — остаток на его счёте LedgerAccountUser, доступный
+// для вывода; LedgerAccountReserved — зарезервированные заявками на вывод средства пользователя.
+// Остальные счета — системные и отражают, откуда пришли или куда ушли деньги.
+const (
+	LedgerAccountUser        = "user"
+	LedgerAccountReserved    = "reserved"
+	LedgerAccountDeposits    = "deposits"
+	LedgerAccountPayouts     = "payouts"
+	LedgerAccountFees        = "fees"
+	LedgerAccountAdjustments = "adjustments"
+	LedgerAccountOpening     = "opening"
+)
+
+// LedgerEntry — запись журнала. Сумма её проводок всегда равна нулю.
+type LedgerEntry struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	Kind          string          `gorm:"index" json:"kind"`
+	Currency      string          `gorm:"size:3" json:"currency"`
+	TransactionID *string         `gorm:"index" json:"transaction_id"` // пополнение, по которому сделана запись
+	WithdrawalID  *uint           `gorm:"index" json:"withdrawal_id"`  // вывод, по которому сделана запись
+	ReversalOf    *uint           `json:"reversal_of"`                 // сторнируемая запись
+	Key           *string         `gorm:"uniqueIndex" json:"key"`      // ключ идемпотентности, например deposit:txid:vout
+	Description   string          `json:"description"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Postings      []LedgerPosting `gorm:"foreignKey:EntryID" json:"postings"`
+}
+
+// LedgerPosting — проводка по одному счёту. Положительная сумма увеличивает остаток счёта,
+// отрицательная уменьшает.
+type LedgerPosting struct {
+	ID       uint         `gorm:"primaryKey" json:"id"`
+	EntryID  uint         `gorm:"index" json:"entry_id"`
+	Account  string       `gorm:"index:idx_ledger_postings_account" json:"account"`
+	UserID   *int64       `gorm:"index:idx_ledger_postings_account" json:"user_id"` // только для счёта пользователя
+	Currency string       `gorm:"size:3" json:"currency"`
+	Amount   money.Amount `json:"amount"`
+}
+
+// BalanceAdjustment — ручная корректировка баланса администратором.
+type BalanceAdjustment struct {
+	ID            uint         `gorm:"primaryKey" json:"id"`
+	UserID        int64        `gorm:"index" json:"user_id"`
+	AdminID       int64        `json:"admin_id"`
+	Amount        money.Amount `json:"amount"`
+	Currency      string       `gorm:"size:3" json:"currency"`
+	Reason        string       `json:"reason"`
+	BalanceBefore money.Amount `json:"balance_before"`
+	BalanceAfter  money.Amount `json:"balance_after"`
+	LedgerEntryID uint         `json:"ledger_entry_id"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// Статусы спора
+const (
+	DisputeStatusOpen     = "open"     // ждёт решения администратора
+	DisputeStatusAccepted = "accepted" // претензия признана, деньги возвращены или зачислены
+	DisputeStatusRejected = "rejected" // претензия отклонена без изменения баланса
+	DisputeStatusAdjusted = "adjusted" // спор закрыт корректировкой баланса на согласованную сумму
+)
+
+// Dispute — спор пользователя по конкретному пополнению (TransactionID и Vout) или выводу (WithdrawalID).
+// Пока спор открыт, зачисление пополнения и смена статуса заявки на вывод заморожены.
+type Dispute struct {
+	ID            uint         `gorm:"primaryKey" json:"id"`
+	UserID        int64        `gorm:"index" json:"user_id"`
+	TransactionID *string      `gorm:"index" json:"transaction_id"`
+	Vout          *int64       `json:"vout"`
+	WithdrawalID  *uint        `gorm:"index" json:"withdrawal_id"`
+	Reason        string       `json:"reason"`
+	Status        string       `gorm:"default:open;index" json:"status"`
+	Amount        money.Amount `json:"amount"` // сумма, проведённая по решению, со знаком для пользователя
+	Currency      string       `gorm:"size:3" json:"currency"`
+	Resolution    string       `json:"resolution"` // комментарий администратора к решению
+	LedgerEntryID *uint        `json:"ledger_entry_id"`
+	ResolvedBy    int64        `json:"resolved_by"`
+	CreatedAt     time.Time    `json:"created_at"`
+	ResolvedAt    *time.Time   `json:"resolved_at"`
+}
+
+// Subject описывает предмет спора для сообщений.
+func (d *Dispute) Subject() string {
+	if d.WithdrawalID != nil {
+		return fmt.Sprintf("вывод #%d", *d.WithdrawalID)
+	}
+	if d.TransactionID != nil && d.Vout != nil {
+		return fmt.Sprintf("пополнение %s:%d", *d.TransactionID, *d.Vout)
+	}
+	return "неизвестный предмет"
+}
+
+// DisputeMessage — сообщение в переписке по спору, пересланное ботом.
+type DisputeMessage struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	DisputeID uint      `gorm:"index" json:"dispute_id"`
+	SenderID  int64     `json:"sender_id"`
+	FromAdmin bool      `json:"from_admin"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WithdrawalLimitOverride — лимиты на вывод, назначенные пользователю администратором вместо настроек.
+// nil — действует значение из настроек, 0 — без ограничения. Суммы действуют, только пока
+// валюта пользователя совпадает с Currency.
+type WithdrawalLimitOverride struct {
+	UserID    int64         `gorm:"primaryKey" json:"user_id"`
+	Currency  string        `gorm:"size:3" json:"currency"` // в какой валюте назначены суммы
+	MinAmount *money.Amount `json:"min_amount"`
+	MaxAmount *money.Amount `json:"max_amount"`
+	Daily     *money.Amount `json:"daily"`
+	Weekly    *money.Amount `json:"weekly"`
+	Monthly   *money.Amount `json:"monthly"`
+	// Общие лимиты на пользователя не действуют, хотя его выводы в них по-прежнему учитываются
+	IgnoreGlobal bool `gorm:"not null;default:false" json:"ignore_global"`
+	// Пауза после смены карты на пользователя не действует
+	IgnoreCardCooldown bool      `gorm:"not null;default:false" json:"ignore_card_cooldown"`
+	SetBy              int64     `json:"set_by"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// WithdrawalLimitUsage — лимит на вывод за период и сколько из него уже использовано.
+type WithdrawalLimitUsage struct {
+	Period string // day, week или month
+	Title  string
+	Scope  string // WithdrawalLimitScopeUser или WithdrawalLimitScopeGlobal
+	Limit  money.Amount
+	Used   money.Amount
+}
+
+// Кому принадлежит лимит
+const (
+	WithdrawalLimitScopeUser   = "user"
+	WithdrawalLimitScopeGlobal = "global"
+)
+
+// WithdrawalLimits — действующие для пользователя лимиты на вывод. Нулевые суммы не ограничивают.
+type WithdrawalLimits struct {
+	UserID        int64
+	Currency      string
+	Min           money.Amount
+	Max           money.Amount
+	Usage         []WithdrawalLimitUsage
+	CooldownUntil *time.Time // до какого времени вывод закрыт после смены карты
+	Override      *WithdrawalLimitOverride
+}
+
+// BalanceDrift — расхождение сохранённого баланса пользователя с остатком по журналу.
+type BalanceDrift struct {
+	UserID   int64
+	Currency string
+	Stored   money.Amount
+	Ledger   money.Amount
+}
@@ -0,0 +1,125 @@
+// Package money хранит денежные суммы в целых минимальных единицах валюты.
+//
+// Политика округления:
+//   - BTC → валюта при зачислении округляется вниз до копейки: пользователь никогда
+//     не получает больше, чем стоит пришедшая сумма;
+//   - валюта → BTC при расчёте суммы к оплате округляется вверх до сатоши: оплата
+//     всегда покрывает запрошенную сумму;
+//   - остальные пересчёты (комиссии, доли) округляются к ближайшему, половина — от нуля.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// MinorUnits — число минимальных единиц в единице валюты. Все поддерживаемые
+// валюты (RUB, USD, EUR, KZT, UAH) делятся на 100.
+const MinorUnits = 100
+
+// Amount — сумма в минимальных единицах валюты (копейках, центах).
+type Amount int64
+
+// FromMajor переводит сумму в единицах валюты в минимальные единицы с округлением к ближайшему.
+func FromMajor(v float64) Amount {
+	return Amount(math.Round(v * MinorUnits))
+}
+
+// Parse разбирает сумму вида "1234.56" или "1234,56" без потери точности.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(strings.Replace(s, ",", ".", 1))
+	if s == "" {
+		return 0, errors.New("пустая сумма")
+	}
+
+	sign := Amount(1)
+	switch s[0] {
+	case '-':
+		sign, s = -1, s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("неверная сумма %q", s)
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("сумма %q точнее копейки", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	units, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/MinorUnits-1 {
+		return 0, fmt.Errorf("неверная сумма %q", s)
+	}
+	minor, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("неверная сумма %q", s)
+	}
+
+	return sign * Amount(units*MinorUnits+minor), nil
+}
+
+// FromBTC переводит сумму в сатоши в валюту по курсу rate (единиц валюты за 1 BTC), округляя вниз.
+// Пересчёт точный: произведение во float64 может оказаться чуть меньше целой копейки и потерять её при округлении.
+func FromBTC(amount btcutil.Amount, rate float64) Amount {
+	x := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(amount)), exactRate(rate))
+	x.Mul(x, big.NewRat(MinorUnits, btcutil.SatoshiPerBitcoin))
+	return Amount(floorRat(x))
+}
+
+// ToBTC переводит сумму в валюте в сатоши по курсу rate, округляя вверх.
+func ToBTC(amount Amount, rate float64) btcutil.Amount {
+	r := exactRate(rate)
+	if r.Sign() == 0 {
+		return 0
+	}
+	x := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(int64(amount)), big.NewInt(btcutil.SatoshiPerBitcoin)), big.NewInt(MinorUnits))
+	x.Quo(x, r)
+	return btcutil.Amount(-floorRat(new(big.Rat).Neg(x)))
+}
+
+// exactRate возвращает курс как десятичную дробь, которой он был записан,
+// а не двоичное приближение float64.
+func exactRate(rate float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(rate, 'f', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// floorRat округляет x вниз до целого.
+func floorRat(x *big.Rat) int64 {
+	// Знаменатель big.Rat всегда положителен, поэтому евклидово деление округляет вниз
+	return new(big.Int).Div(x.Num(), x.Denom()).Int64()
+}
+
+// Major возвращает сумму в единицах валюты. Только для отображения и сравнения с настройками.
+func (a Amount) Major() float64 {
+	return float64(a) / MinorUnits
+}
+
+// Mul умножает сумму на коэффициент с округлением к ближайшему.
+func (a Amount) Mul(factor float64) Amount {
+	return Amount(math.Round(float64(a) * factor))
+}
+
+// String форматирует сумму с двумя знаками после точки, например "-1234.50".
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/MinorUnits, a%MinorUnits)
+}
@@ -0,0 +1,63 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+func TestFromBTC(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount btcutil.Amount
+		rate   float64
+		want   Amount
+	}{
+		// Во float64 1e8 * 0.29 * 100 / 1e8 = 28.999999999999996
+		{"float product just below a kopeck", btcutil.SatoshiPerBitcoin, 0.29, 29},
+		{"float product just below a kopeck, larger rate", btcutil.SatoshiPerBitcoin, 1.13, 113},
+		{"rounds down", 1, 6543210.12, 6},
+		{"whole bitcoin", btcutil.SatoshiPerBitcoin, 6543210.12, 654321012},
+		{"zero rate", btcutil.SatoshiPerBitcoin, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromBTC(tt.amount, tt.rate); got != tt.want {
+				t.Errorf("FromBTC(%d, %v) = %d, want %d", tt.amount, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToBTC(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Amount
+		rate   float64
+		want   btcutil.Amount
+	}{
+		// Во float64 1 / 781.25 * 1e8 = 128000.00000000001
+		{"float quotient just above a satoshi", 100, 781.25, 128000},
+		{"rounds up", 1, 6543210.12, 1},
+		{"exact", 654321012, 6543210.12, btcutil.SatoshiPerBitcoin},
+		{"zero rate", 100, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToBTC(tt.amount, tt.rate); got != tt.want {
+				t.Errorf("ToBTC(%d, %v) = %d, want %d", tt.amount, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTripCoversAmount(t *testing.T) {
+	// Оплата, рассчитанная ToBTC, при зачислении по тому же курсу даёт не меньше запрошенного
+	for _, rate := range []float64{0.29, 781.25, 95000.01, 6543210.12} {
+		for _, amount := range []Amount{1, 99, 2900, 12345, 100000} {
+			if got := FromBTC(ToBTC(amount, rate), rate); got < amount {
+				t.Errorf("rate %v: FromBTC(ToBTC(%d)) = %d", rate, amount, got)
+			}
+		}
+	}
+}
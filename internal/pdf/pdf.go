@@ -0,0 +1,100 @@
+// Package pdf формирует простые текстовые PDF-документы без внешних зависимостей:
+// строки моноширинным шрифтом Courier на страницах A4.
+//
+// Встроенные шрифты PDF не содержат кириллицы, поэтому символы вне ASCII
+// заменяются на «?» — текст документа следует писать латиницей.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth    = 595
+	pageHeight   = 842
+	margin       = 40
+	fontSize     = 9
+	lineHeight   = 12
+	linesPerPage = (pageHeight - 2*margin) / lineHeight
+)
+
+// Text возвращает документ, в котором каждая строка lines выводится отдельной строкой.
+// Длинные документы разбиваются на страницы.
+func Text(lines []string) []byte {
+	var pages [][]string
+	for len(lines) > linesPerPage {
+		pages = append(pages, lines[:linesPerPage])
+		lines = lines[linesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Объекты: 1 — каталог, 2 — дерево страниц, 3 — шрифт,
+	// далее на каждую страницу пара «страница, содержимое»
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // дерево страниц заполняется, когда известны номера страниц
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	}
+
+	var kids []string
+	for _, page := range pages {
+		pageID := len(objects) + 1
+		contentID := pageID + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID))
+
+		content := pageContent(page)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+				"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, contentID),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
+
+func pageContent(lines []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, lineHeight, margin, pageHeight-margin-fontSize)
+	for _, line := range lines {
+		fmt.Fprintf(&sb, "(%s) Tj T*\n", escape(line))
+	}
+	sb.WriteString("ET")
+	return sb.String()
+}
+
+// escape экранирует спецсимволы строкового литерала PDF и заменяет символы вне ASCII.
+func escape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < ' ' || r > '~':
+			sb.WriteByte('?')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,101 @@
+package pricing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tier — спред для пользователей с оборотом пополнений не меньше MinVolume (в валюте пользователя).
+type Tier struct {
+	MinVolume float64
+	Spread    float64 // доля, например 0.02 — 2%
+}
+
+// Pricing определяет, по какому курсу зачисляются пополнения.
+type Pricing struct {
+	baseSpread float64
+	vipSpread  float64
+	tiers      []Tier
+}
+
+// Price — курс зачисления для конкретного пользователя.
+type Price struct {
+	MarketRate    float64
+	EffectiveRate float64
+	Spread        float64
+	Tier          string
+}
+
+// New принимает спреды в процентах и уровни в формате "оборот:спред,оборот:спред".
+func New(baseSpreadPercent, vipSpreadPercent float64, tiers string) (*Pricing, error) {
+	parsed, err := ParseTiers(tiers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spread := range []float64{baseSpreadPercent, vipSpreadPercent} {
+		if spread < 0 || spread >= 100 {
+			return nil, fmt.Errorf("spread must be in [0, 100), got %v", spread)
+		}
+	}
+
+	return &Pricing{
+		baseSpread: baseSpreadPercent / 100,
+		vipSpread:  vipSpreadPercent / 100,
+		tiers:      parsed,
+	}, nil
+}
+
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		volumeStr, spreadStr, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid spread tier %q, expected volume:percent", part)
+		}
+
+		volume, err := strconv.ParseFloat(strings.TrimSpace(volumeStr), 64)
+		if err != nil || volume < 0 {
+			return nil, fmt.Errorf("invalid tier volume %q", volumeStr)
+		}
+
+		spread, err := strconv.ParseFloat(strings.TrimSpace(spreadStr), 64)
+		if err != nil || spread < 0 || spread >= 100 {
+			return nil, fmt.Errorf("invalid tier spread %q", spreadStr)
+		}
+
+		tiers = append(tiers, Tier{MinVolume: volume, Spread: spread / 100})
+	}
+
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinVolume < tiers[j].MinVolume })
+	return tiers, nil
+}
+
+// BuyPrice считает курс зачисления по рыночному курсу, обороту пользователя и VIP-статусу.
+func (p *Pricing) BuyPrice(marketRate, volume float64, vip bool) Price {
+	spread, tier := p.baseSpread, "базовый"
+
+	if vip {
+		spread, tier = p.vipSpread, "VIP"
+	} else {
+		for _, t := range p.tiers {
+			if volume >= t.MinVolume {
+				spread, tier = t.Spread, fmt.Sprintf("оборот от %.0f", t.MinVolume)
+			}
+		}
+	}
+
+	return Price{
+		MarketRate:    marketRate,
+		EffectiveRate: marketRate * (1 - spread),
+		Spread:        spread,
+		Tier:          tier,
+	}
+}
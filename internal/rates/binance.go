@@ -0,0 +1,42 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Binance берёт курс напрямую с пары BTC<валюта>, например BTCRUB.
+type Binance struct {
+	httpClient *http.Client
+}
+
+func NewBinance(httpClient *http.Client) *Binance {
+	return &Binance{httpClient: httpClient}
+}
+
+func (b *Binance) Name() string {
+	return "binance"
+}
+
+func (b *Binance) BTCRate(ctx context.Context, currency string) (float64, error) {
+	var data struct {
+		Symbol string `json:"symbol"`
+		Price  string `json:"price"`
+	}
+
+	url := "https://api.binance.com/api/v3/ticker/price?symbol=BTC" + strings.ToUpper(currency)
+	err := getJSON(ctx, b.httpClient, url, "Binance", &data)
+	if err != nil {
+		return 0, err
+	}
+
+	rate, err := strconv.ParseFloat(data.Price, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price format from Binance: %v", err)
+	}
+
+	return rate, nil
+}
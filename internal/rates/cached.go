@@ -0,0 +1,41 @@
+package rates
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cached запоминает последний полученный курс каждой валюты на время ttl.
+type Cached struct {
+	provider Provider
+	ttl      time.Duration
+
+	mu   sync.Mutex
+	last map[string]Rate
+}
+
+func NewCached(provider Provider, ttl time.Duration) *Cached {
+	return &Cached{
+		provider: provider,
+		ttl:      ttl,
+		last:     make(map[string]Rate),
+	}
+}
+
+func (c *Cached) BTCRate(ctx context.Context, currency string) (Rate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if last, ok := c.last[currency]; ok && time.Since(last.Time) < c.ttl {
+		return last, nil
+	}
+
+	rate, err := c.provider.BTCRate(ctx, currency)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	c.last[currency] = rate
+	return rate, nil
+}
@@ -0,0 +1,38 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CoinGecko отдаёт агрегированный по биржам курс BTC к валюте.
+type CoinGecko struct {
+	httpClient *http.Client
+}
+
+func NewCoinGecko(httpClient *http.Client) *CoinGecko {
+	return &CoinGecko{httpClient: httpClient}
+}
+
+func (c *CoinGecko) Name() string {
+	return "coingecko"
+}
+
+func (c *CoinGecko) BTCRate(ctx context.Context, currency string) (float64, error) {
+	var data map[string]map[string]float64
+
+	vsCurrency := strings.ToLower(currency)
+	url := "https://api.coingecko.com/api/v3/simple/price?ids=bitcoin&vs_currencies=" + vsCurrency
+	if err := getJSON(ctx, c.httpClient, url, "CoinGecko", &data); err != nil {
+		return 0, err
+	}
+
+	rate, ok := data["bitcoin"][vsCurrency]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("BTC/%s price not found in CoinGecko response", strings.ToUpper(currency))
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,136 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KrakenFX считает курс через BTC/USD с Kraken и курс USD к валюте из open.er-api.com.
+type KrakenFX struct {
+	httpClient *http.Client
+
+	// Поля для кэширования курсов USD
+	mu             sync.Mutex // Защищает доступ к полям кэша
+	usdRates       map[string]float64
+	nextUpdateUnix int64
+}
+
+// krakenResponse определяет структуру ответа от API Kraken.
+// Для "result" используется map, так как ключ ("XXBTZUSD") динамический.
+type krakenResponse struct {
+	Error  []string                `json:"error"`
+	Result map[string]krakenTicker `json:"result"`
+}
+
+type krakenTicker struct {
+	// c = last trade closed array(<price>, <lot volume>)
+	LastTrade []string `json:"c"`
+}
+
+// exchangeRateResponse определяет структуру ответа от API обменных курсов.
+type exchangeRateResponse struct {
+	Result         string             `json:"result"`
+	Rates          map[string]float64 `json:"rates"`
+	TimeNextUpdate int64              `json:"time_next_update_unix"`
+}
+
+func NewKrakenFX(httpClient *http.Client) *KrakenFX {
+	return &KrakenFX{httpClient: httpClient}
+}
+
+func (k *KrakenFX) Name() string {
+	return "kraken×fx"
+}
+
+func (k *KrakenFX) BTCRate(ctx context.Context, currency string) (float64, error) {
+	type btcResult struct {
+		price float64
+		err   error
+	}
+	btcChan := make(chan btcResult, 1)
+
+	// Курс BTC/USD запрашиваем параллельно с курсом USD к валюте
+	go func() {
+		btcPrice, err := k.getBTCUSDPrice(ctx)
+		btcChan <- btcResult{price: btcPrice, err: err}
+	}()
+
+	fxRate, err := k.getUSDRate(ctx, currency)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get USD/%s rate: %v", currency, err)
+	}
+
+	result := <-btcChan
+	if result.err != nil {
+		return 0, fmt.Errorf("failed to get BTC/USD price: %v", result.err)
+	}
+
+	return result.price * fxRate, nil
+}
+
+func (k *KrakenFX) getBTCUSDPrice(ctx context.Context) (float64, error) {
+	var data krakenResponse
+	if err := getJSON(ctx, k.httpClient, "https://api.kraken.com/0/public/Ticker?pair=XBTUSD", "Kraken", &data); err != nil {
+		return 0, err
+	}
+
+	if len(data.Error) > 0 {
+		return 0, fmt.Errorf("Kraken API error: %v", data.Error)
+	}
+
+	// Kraken возвращает пару XBTUSD под ключом XXBTZUSD
+	tickerData, ok := data.Result["XXBTZUSD"]
+	if !ok {
+		return 0, fmt.Errorf("XXBTZUSD pair not found in Kraken response")
+	}
+
+	if len(tickerData.LastTrade) == 0 {
+		return 0, fmt.Errorf("price data is missing in Kraken response")
+	}
+
+	price, err := strconv.ParseFloat(tickerData.LastTrade[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price format from Kraken: %v", err)
+	}
+
+	return price, nil
+}
+
+// getUSDRate получает курс USD к валюте, используя кэш до времени следующего обновления API.
+func (k *KrakenFX) getUSDRate(ctx context.Context, currency string) (float64, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Now().Unix() >= k.nextUpdateUnix {
+		if err := k.refreshUSDRates(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	rate, ok := k.usdRates[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("%s rate not found in exchange rate API response", currency)
+	}
+	return rate, nil
+}
+
+func (k *KrakenFX) refreshUSDRates(ctx context.Context) error {
+	var data exchangeRateResponse
+	if err := getJSON(ctx, k.httpClient, "https://open.er-api.com/v6/latest/USD", "exchange rate API", &data); err != nil {
+		return err
+	}
+
+	if data.Result != "success" {
+		return fmt.Errorf("exchange rate API returned an error status: %s", data.Result)
+	}
+
+	k.usdRates = data.Rates
+	k.nextUpdateUnix = data.TimeNextUpdate
+
+	return nil
+}
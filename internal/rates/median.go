@@ -0,0 +1,112 @@
+package rates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fi44er/btc_bot/utils"
+)
+
+// Median опрашивает все источники параллельно и берёт медиану их курсов,
+// отбрасывая значения, отклоняющиеся от медианы больше чем на maxDeviation.
+type Median struct {
+	sources      []Source
+	maxDeviation float64 // доля, например 0.03 — 3%
+	logger       *utils.Logger
+}
+
+func NewMedian(sources []Source, maxDeviation float64, logger *utils.Logger) *Median {
+	return &Median{
+		sources:      sources,
+		maxDeviation: maxDeviation,
+		logger:       logger,
+	}
+}
+
+type sourceRate struct {
+	source string
+	value  float64
+}
+
+func (m *Median) BTCRate(ctx context.Context, currency string) (Rate, error) {
+	results := make([]sourceRate, len(m.sources))
+	errs := make([]error, len(m.sources))
+
+	var wg sync.WaitGroup
+	for i, source := range m.sources {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			value, err := source.BTCRate(ctx, currency)
+			if err == nil && (value <= 0 || math.IsNaN(value) || math.IsInf(value, 0)) {
+				err = fmt.Errorf("invalid rate %v", value)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", source.Name(), err)
+				return
+			}
+			results[i] = sourceRate{source: source.Name(), value: value}
+		}(i, source)
+	}
+	wg.Wait()
+
+	var rates []sourceRate
+	for i, result := range results {
+		if errs[i] != nil {
+			m.logger.Warnf("BTC/%s rate source failed: %v", currency, errs[i])
+			continue
+		}
+		rates = append(rates, result)
+	}
+
+	if len(rates) == 0 {
+		return Rate{}, fmt.Errorf("all rate sources failed: %w", errors.Join(errs...))
+	}
+
+	median := medianOf(rates)
+
+	var accepted []sourceRate
+	for _, rate := range rates {
+		if math.Abs(rate.value-median)/median <= m.maxDeviation {
+			accepted = append(accepted, rate)
+		} else {
+			m.logger.Warnf("BTC/%s rate from %s (%.2f) deviates from median %.2f, rejecting", currency, rate.source, rate.value, median)
+		}
+	}
+
+	if len(accepted) == 0 {
+		return Rate{}, fmt.Errorf("rate sources disagree: no rate within %.1f%% of median %.2f", m.maxDeviation*100, median)
+	}
+
+	names := make([]string, len(accepted))
+	for i, rate := range accepted {
+		names[i] = rate.source
+	}
+
+	return Rate{
+		Currency: currency,
+		Value:    medianOf(accepted),
+		Source:   strings.Join(names, ","),
+		Time:     time.Now(),
+	}, nil
+}
+
+func medianOf(rates []sourceRate) float64 {
+	values := make([]float64, len(rates))
+	for i, rate := range rates {
+		values[i] = rate.value
+	}
+	sort.Float64s(values)
+
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
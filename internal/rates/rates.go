@@ -0,0 +1,90 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Rate — курс BTC к фиатной валюте, полученный из источника.
+type Rate struct {
+	Currency string
+	Value    float64
+	Source   string
+	Time     time.Time
+}
+
+// Provider отдаёт актуальный курс BTC к валюте (код ISO 4217, например RUB).
+type Provider interface {
+	BTCRate(ctx context.Context, currency string) (Rate, error)
+}
+
+// Source — отдельный источник курса (биржа или связка бирж).
+type Source interface {
+	Name() string
+	BTCRate(ctx context.Context, currency string) (float64, error)
+}
+
+// SourcesByName собирает источники по именам из конфига.
+func SourcesByName(names []string) ([]Source, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	var sources []Source
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case "binance":
+			sources = append(sources, NewBinance(httpClient))
+		case "kraken":
+			sources = append(sources, NewKrakenFX(httpClient))
+		case "coingecko":
+			sources = append(sources, NewCoinGecko(httpClient))
+		default:
+			return nil, fmt.Errorf("unknown rate source %q", name)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no rate sources configured")
+	}
+	return sources, nil
+}
+
+// serviceError — ответ API с неуспешным HTTP-статусом.
+type serviceError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+func getJSON(ctx context.Context, httpClient *http.Client, url, apiName string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request to %s: %v", apiName, err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %v", apiName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &serviceError{
+			StatusCode: resp.StatusCode,
+			Message:    "bad response from " + apiName,
+		}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse %s response: %v", apiName, err)
+	}
+	return nil
+}
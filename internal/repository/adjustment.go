@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"gorm.io/gorm"
+)
+
+func (r *Repository) CreateBalanceAdjustment(ctx context.Context, adjustment *models.BalanceAdjustment, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	if err := db.WithContext(ctx).Create(adjustment).Error; err != nil {
+		return fmt.Errorf("failed to save balance adjustment of user %d: %w", adjustment.UserID, err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (r *Repository) CreateDispute(ctx context.Context, dispute *models.Dispute, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	if err := db.WithContext(ctx).Create(dispute).Error; err != nil {
+		return fmt.Errorf("failed to save dispute of user %d: %w", dispute.UserID, err)
+	}
+	return nil
+}
+
+func (r *Repository) UpdateDispute(ctx context.Context, dispute *models.Dispute, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+	return db.WithContext(ctx).Save(dispute).Error
+}
+
+func (r *Repository) GetDisputeByID(ctx context.Context, id int64) (*models.Dispute, error) {
+	var dispute models.Dispute
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&dispute).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dispute %d: %w", id, err)
+	}
+	return &dispute, nil
+}
+
+// LockDispute читает спор с блокировкой строки до конца транзакции tx.
+func (r *Repository) LockDispute(ctx context.Context, id int64, tx *gorm.DB) (*models.Dispute, error) {
+	var dispute models.Dispute
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).
+		First(&dispute).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock dispute %d: %w", id, err)
+	}
+	return &dispute, nil
+}
+
+// GetOpenDisputes возвращает все открытые споры, от старых к новым.
+func (r *Repository) GetOpenDisputes(ctx context.Context) ([]models.Dispute, error) {
+	var disputes []models.Dispute
+	err := r.db.WithContext(ctx).
+		Where("status = ?", models.DisputeStatusOpen).
+		Order("id ASC").
+		Find(&disputes).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get open disputes: %w", err)
+	}
+	return disputes, nil
+}
+
+func (r *Repository) GetDisputesByUser(ctx context.Context, userID int64, limit int) ([]models.Dispute, error) {
+	var disputes []models.Dispute
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&disputes).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disputes of user %d: %w", userID, err)
+	}
+	return disputes, nil
+}
+
+// CountOpenUserDisputes возвращает, сколько у пользователя открытых споров.
+func (r *Repository) CountOpenUserDisputes(ctx context.Context, userID int64, tx *gorm.DB) (int64, error) {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	var count int64
+	err := db.WithContext(ctx).
+		Model(&models.Dispute{}).
+		Where("user_id = ? AND status = ?", userID, models.DisputeStatusOpen).
+		Count(&count).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count open disputes of user %d: %w", userID, err)
+	}
+	return count, nil
+}
+
+// GetOpenWithdrawalDispute возвращает открытый спор по заявке на вывод или nil.
+func (r *Repository) GetOpenWithdrawalDispute(ctx context.Context, withdrawalID uint, tx *gorm.DB) (*models.Dispute, error) {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	var dispute models.Dispute
+	err := db.WithContext(ctx).
+		Where("withdrawal_id = ? AND status = ?", withdrawalID, models.DisputeStatusOpen).
+		First(&dispute).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dispute of withdrawal %d: %w", withdrawalID, err)
+	}
+	return &dispute, nil
+}
+
+// GetOpenDepositDisputes возвращает выходы txid:vout пользователя, по которым открыт спор.
+func (r *Repository) GetOpenDepositDisputes(ctx context.Context, userID int64, tx *gorm.DB) (map[string]bool, error) {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	var disputes []models.Dispute
+	err := db.WithContext(ctx).
+		Where("user_id = ? AND transaction_id IS NOT NULL AND status = ?", userID, models.DisputeStatusOpen).
+		Find(&disputes).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deposit disputes of user %d: %w", userID, err)
+	}
+
+	outpoints := make(map[string]bool, len(disputes))
+	for _, dispute := range disputes {
+		outpoints[fmt.Sprintf("%s:%d", *dispute.TransactionID, *dispute.Vout)] = true
+	}
+	return outpoints, nil
+}
+
+func (r *Repository) CreateDisputeMessage(ctx context.Context, message *models.DisputeMessage) error {
+	if err := r.db.WithContext(ctx).Create(message).Error; err != nil {
+		return fmt.Errorf("failed to save message of dispute %d: %w", message.DisputeID, err)
+	}
+	return nil
+}
+
+func (r *Repository) GetDisputeMessages(ctx context.Context, disputeID uint) ([]models.DisputeMessage, error) {
+	var messages []models.DisputeMessage
+	err := r.db.WithContext(ctx).
+		Where("dispute_id = ?", disputeID).
+		Order("id ASC").
+		Find(&messages).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages of dispute %d: %w", disputeID, err)
+	}
+	return messages, nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SaveExchangeRate сохраняет курс; повторное сохранение того же курса игнорируется.
+func (r *Repository) SaveExchangeRate(ctx context.Context, rate *models.ExchangeRate) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(rate).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("failed to save exchange rate: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetLatestExchangeRate(ctx context.Context, currency string) (*models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	err := r.db.WithContext(ctx).
+		Where("currency = ?", currency).
+		Order("fetched_at DESC").
+		First(&rate).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest BTC/%s rate: %w", currency, err)
+	}
+	return &rate, nil
+}
+
+// GetExchangeRateAt возвращает последний курс, полученный не позже at.
+func (r *Repository) GetExchangeRateAt(ctx context.Context, currency string, at time.Time) (*models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	err := r.db.WithContext(ctx).
+		Where("currency = ? AND fetched_at <= ?", currency, at).
+		Order("fetched_at DESC").
+		First(&rate).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get BTC/%s rate at %s: %w", currency, at, err)
+	}
+	return &rate, nil
+}
+
+func (r *Repository) GetExchangeRates(ctx context.Context, currency string, from, to time.Time) ([]models.ExchangeRate, error) {
+	var rates []models.ExchangeRate
+	err := r.db.WithContext(ctx).
+		Where("currency = ? AND fetched_at >= ? AND fetched_at < ?", currency, from, to).
+		Order("fetched_at ASC").
+		Find(&rates).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exchange rates: %w", err)
+	}
+	return rates, nil
+}
@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostLedgerEntry сохраняет запись журнала и в той же транзакции применяет её проводки
+// по счетам пользователей к их балансам. Балансы меняются приращением, а списание
+// проходит только при достаточном остатке, иначе возвращается models.ErrInsufficientFunds.
+// Запись с уже проведённым ключом не сохраняется, возвращается models.ErrAlreadyPosted.
+func (r *Repository) PostLedgerEntry(ctx context.Context, entry *models.LedgerEntry, tx *gorm.DB) error {
+	if tx == nil {
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return r.PostLedgerEntry(ctx, entry, tx)
+		})
+	}
+
+	res := tx.WithContext(ctx).
+		Omit("Postings").
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+		Create(entry)
+	if res.Error != nil {
+		return fmt.Errorf("failed to save ledger entry: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return models.ErrAlreadyPosted
+	}
+
+	for i := range entry.Postings {
+		entry.Postings[i].EntryID = entry.ID
+	}
+	if err := tx.WithContext(ctx).Create(&entry.Postings).Error; err != nil {
+		return fmt.Errorf("failed to save ledger postings: %w", err)
+	}
+
+	for _, posting := range entry.Postings {
+		if posting.Account != models.LedgerAccountUser {
+			continue
+		}
+
+		query := tx.WithContext(ctx).
+			Model(&models.User{}).
+			Where("telegram_id = ? AND currency = ?", *posting.UserID, posting.Currency)
+		if posting.Amount < 0 {
+			query = query.Where("balance >= ?", -posting.Amount)
+		}
+
+		res := query.Update("balance", gorm.Expr("balance + ?", posting.Amount))
+		if res.Error != nil {
+			return fmt.Errorf("failed to apply ledger entry to balance of user %d: %w", *posting.UserID, res.Error)
+		}
+		if res.RowsAffected > 0 {
+			continue
+		}
+
+		var found int64
+		err := tx.WithContext(ctx).
+			Model(&models.User{}).
+			Where("telegram_id = ? AND currency = ?", *posting.UserID, posting.Currency).
+			Count(&found).
+			Error
+		if err != nil {
+			return fmt.Errorf("failed to check user %d: %w", *posting.UserID, err)
+		}
+		if found > 0 {
+			return models.ErrInsufficientFunds
+		}
+		return fmt.Errorf("пользователь %d с валютой %s не найден", *posting.UserID, posting.Currency)
+	}
+
+	return nil
+}
+
+// RebuildUserBalance пересчитывает баланс пользователя по журналу в его текущей валюте.
+// Возвращает расхождение или nil, если баланс совпадает. При fix сохраняет баланс из журнала.
+func (r *Repository) RebuildUserBalance(ctx context.Context, userID int64, fix bool) (*models.BalanceDrift, error) {
+	var drift *models.BalanceDrift
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Блокируем пользователя, чтобы не пропустить проводку, сделанную во время пересчёта
+		var user models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&user, "telegram_id = ?", userID).
+			Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("пользователь с telegram_id %d не найден", userID)
+		}
+		if err != nil {
+			return err
+		}
+
+		var ledger money.Amount
+		err = tx.Model(&models.LedgerPosting{}).
+			Where("account = ? AND user_id = ? AND currency = ?", models.LedgerAccountUser, userID, user.Currency).
+			Select("COALESCE(SUM(amount),0)").
+			Scan(&ledger).
+			Error
+		if err != nil {
+			return fmt.Errorf("failed to sum ledger of user %d: %w", userID, err)
+		}
+
+		if ledger == user.Balance {
+			return nil
+		}
+
+		drift = &models.BalanceDrift{
+			UserID:   userID,
+			Currency: user.Currency,
+			Stored:   user.Balance,
+			Ledger:   ledger,
+		}
+		if !fix {
+			return nil
+		}
+
+		return tx.Model(&models.User{}).
+			Where("telegram_id = ?", userID).
+			Update("balance", ledger).
+			Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return drift, nil
+}
+
+func (r *Repository) GetAllUserIDs(ctx context.Context) ([]int64, error) {
+	var ids []int64
+	err := r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Order("telegram_id").
+		Pluck("telegram_id", &ids).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return ids, nil
+}
+
+// GetLedgerKeys возвращает ключи записей вида kind, проведённых по счёту пользователя.
+func (r *Repository) GetLedgerKeys(ctx context.Context, userID int64, kind string) (map[string]bool, error) {
+	var keys []string
+	err := r.db.WithContext(ctx).
+		Model(&models.LedgerEntry{}).
+		Joins("JOIN ledger_postings ON ledger_postings.entry_id = ledger_entries.id").
+		Where("ledger_entries.kind = ? AND ledger_entries.key IS NOT NULL", kind).
+		Where("ledger_postings.account = ? AND ledger_postings.user_id = ?", models.LedgerAccountUser, userID).
+		Pluck("ledger_entries.key", &keys).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger keys of user %d: %w", userID, err)
+	}
+
+	result := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		result[key] = true
+	}
+	return result, nil
+}
+
+// GetLedgerEntriesByUser возвращает записи журнала по счёту пользователя, кроме видов exclude,
+// с проводками только по этому счёту.
+func (r *Repository) GetLedgerEntriesByUser(ctx context.Context, userID int64, exclude ...string) ([]models.LedgerEntry, error) {
+	accounts := []string{models.LedgerAccountUser, models.LedgerAccountReserved}
+	query := r.db.WithContext(ctx).
+		Preload("Postings", "account IN ? AND user_id = ?", accounts, userID).
+		Where("id IN (?)", r.db.Model(&models.LedgerPosting{}).
+			Select("entry_id").
+			Where("account IN ? AND user_id = ?", accounts, userID))
+	if len(exclude) > 0 {
+		query = query.Where("kind NOT IN ?", exclude)
+	}
+
+	var entries []models.LedgerEntry
+	if err := query.Order("created_at DESC").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get ledger entries of user %d: %w", userID, err)
+	}
+	return entries, nil
+}
+
+// SumWithdrawalHold возвращает, сколько сейчас зарезервировано по заявке на вывод.
+func (r *Repository) SumWithdrawalHold(ctx context.Context, withdrawalID uint, tx *gorm.DB) (money.Amount, error) {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	var sum money.Amount
+	err := db.WithContext(ctx).
+		Model(&models.LedgerPosting{}).
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_postings.entry_id").
+		Where("ledger_entries.withdrawal_id = ? AND ledger_postings.account = ?", withdrawalID, models.LedgerAccountReserved).
+		Select("COALESCE(SUM(ledger_postings.amount),0)").
+		Scan(&sum).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum hold of withdrawal %d: %w", withdrawalID, err)
+	}
+	return sum, nil
+}
+
+// SumUserReserved возвращает остаток пользователя на счёте резерва в валюте currency —
+// сумму с комиссией, зарезервированную его незавершёнными заявками на вывод.
+func (r *Repository) SumUserReserved(ctx context.Context, userID int64, currency string) (money.Amount, error) {
+	var sum money.Amount
+	err := r.db.WithContext(ctx).
+		Model(&models.LedgerPosting{}).
+		Where("account = ? AND user_id = ? AND currency = ?", models.LedgerAccountReserved, userID, currency).
+		Select("COALESCE(SUM(amount),0)").
+		Scan(&sum).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum reserve of user %d: %w", userID, err)
+	}
+	return sum, nil
+}
+
+// SumUserLedger возвращает средства пользователя в валюте currency — доступные и зарезервированные —
+// на момент before.
+func (r *Repository) SumUserLedger(ctx context.Context, userID int64, currency string, before time.Time) (money.Amount, error) {
+	var sum money.Amount
+	err := r.db.WithContext(ctx).
+		Model(&models.LedgerPosting{}).
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_postings.entry_id").
+		Where("ledger_postings.account IN ? AND ledger_postings.user_id = ? AND ledger_postings.currency = ?",
+			[]string{models.LedgerAccountUser, models.LedgerAccountReserved}, userID, currency).
+		Where("ledger_entries.created_at < ?", before).
+		Select("COALESCE(SUM(ledger_postings.amount),0)").
+		Scan(&sum).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum ledger of user %d: %w", userID, err)
+	}
+	return sum, nil
+}
+
+// GetUserLedgerEntriesBetween возвращает записи журнала по счетам пользователя в валюте currency
+// за период [from, to) в хронологическом порядке, с проводками только по этим счетам.
+func (r *Repository) GetUserLedgerEntriesBetween(ctx context.Context, userID int64, currency string, from, to time.Time) ([]models.LedgerEntry, error) {
+	accounts := []string{models.LedgerAccountUser, models.LedgerAccountReserved}
+	var entries []models.LedgerEntry
+	err := r.db.WithContext(ctx).
+		Preload("Postings", "account IN ? AND user_id = ?", accounts, userID).
+		Where("id IN (?)", r.db.Model(&models.LedgerPosting{}).
+			Select("entry_id").
+			Where("account IN ? AND user_id = ?", accounts, userID)).
+		Where("currency = ? AND created_at >= ? AND created_at < ?", currency, from, to).
+		Order("created_at, id").
+		Find(&entries).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger entries of user %d: %w", userID, err)
+	}
+	return entries, nil
+}
+
+// SumWithdrawalHolds возвращает, сколько было зарезервировано под заявки на вывод в валюте currency
+// начиная с since, без отменённых заявок. userID 0 — по всем пользователям.
+func (r *Repository) SumWithdrawalHolds(ctx context.Context, userID int64, currency string, since time.Time, tx *gorm.DB) (money.Amount, error) {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	query := db.WithContext(ctx).
+		Model(&models.LedgerPosting{}).
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_postings.entry_id").
+		Joins("JOIN withdrawals ON withdrawals.id = ledger_entries.withdrawal_id").
+		Where("ledger_entries.kind = ? AND ledger_postings.account = ?", models.LedgerEntryHold, models.LedgerAccountReserved).
+		Where("ledger_postings.currency = ? AND ledger_entries.created_at >= ?", currency, since).
+		Where("withdrawals.status <> ?", models.WithdrawalStatusCanceled)
+	if userID != 0 {
+		query = query.Where("ledger_postings.user_id = ?", userID)
+	}
+
+	var sum money.Amount
+	if err := query.Select("COALESCE(SUM(ledger_postings.amount),0)").Scan(&sum).Error; err != nil {
+		return 0, fmt.Errorf("failed to sum withdrawal holds since %s: %w", since, err)
+	}
+	return sum, nil
+}
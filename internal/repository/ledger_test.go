@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/utils"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+// testRepository подключается к Postgres из TEST_DB_URL и создаёт схему в отдельном
+// временном schema, которое удаляется после теста. Без TEST_DB_URL тест пропускается:
+// проводки опираются на ON CONFLICT и блокировки строк Postgres, поэтому подменять базу нельзя.
+func testRepository(t *testing.T) (*Repository, *gorm.DB) {
+	t.Helper()
+
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		t.Skip("TEST_DB_URL не задан, тест с базой пропущен")
+	}
+
+	config := &gorm.Config{Logger: gormLogger.Default.LogMode(gormLogger.Silent)}
+	admin, err := gorm.Open(postgres.Open(url), config)
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+
+	schema := fmt.Sprintf("test_ledger_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	separator := " "
+	if strings.Contains(url, "://") {
+		separator = "&"
+		if !strings.Contains(url, "?") {
+			separator = "?"
+		}
+	}
+	db, err := gorm.Open(postgres.Open(url+separator+"search_path="+schema), config)
+	if err != nil {
+		t.Fatalf("failed to connect to test schema: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get connection pool: %v", err)
+	}
+	// Горутин больше, чем соединений: проводки ещё и ждут друг друга в очереди пула
+	sqlDB.SetMaxOpenConns(20)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(
+		&models.SystemWallet{},
+		&models.User{},
+		&models.Transaction{},
+		&models.Withdrawal{},
+		&models.LedgerEntry{},
+		&models.LedgerPosting{},
+	)
+	if err != nil {
+		t.Fatalf("failed to migrate test schema: %v", err)
+	}
+
+	return NewRepository(db, utils.InitLogger()), db
+}
+
+// transfer собирает запись журнала, переводящую amount на счёт пользователя с системного счёта account
+// (или обратно при отрицательной сумме).
+func transfer(kind, key string, userID int64, account string, amount money.Amount) *models.LedgerEntry {
+	return &models.LedgerEntry{
+		Kind:     kind,
+		Currency: "RUB",
+		Key:      &key,
+		Postings: []models.LedgerPosting{
+			{Account: models.LedgerAccountUser, UserID: &userID, Currency: "RUB", Amount: amount},
+			{Account: account, Currency: "RUB", Amount: -amount},
+		},
+	}
+}
+
+func userLedgerSum(t *testing.T, db *gorm.DB, userID int64) money.Amount {
+	t.Helper()
+	var sum money.Amount
+	err := db.Model(&models.LedgerPosting{}).
+		Where("account = ? AND user_id = ?", models.LedgerAccountUser, userID).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&sum).
+		Error
+	if err != nil {
+		t.Fatalf("failed to sum ledger: %v", err)
+	}
+	return sum
+}
+
+// TestPostLedgerEntryConcurrent проводит пополнения и списания одного пользователя параллельно:
+// ни одно приращение не должно потеряться, а списания сверх остатка — отклоняться, а не уводить баланс в минус.
+func TestPostLedgerEntryConcurrent(t *testing.T) {
+	repo, db := testRepository(t)
+	ctx := context.Background()
+
+	const (
+		userID      int64        = 1001
+		initial     money.Amount = 10000
+		deposits                 = 50
+		deposit     money.Amount = 1000
+		withdrawals              = 50
+		withdrawal  money.Amount = 3000
+	)
+	if err := db.Create(&models.User{TelegramID: userID, Currency: "RUB"}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := repo.PostLedgerEntry(ctx, transfer(models.LedgerEntryOpening, "opening", userID, models.LedgerAccountOpening, initial), nil); err != nil {
+		t.Fatalf("failed to post opening balance: %v", err)
+	}
+
+	var (
+		wg                   sync.WaitGroup
+		mu                   sync.Mutex
+		deposited, withdrawn int
+		rejected             int
+		unexpected           []error
+	)
+	start := make(chan struct{})
+	post := func(entry *models.LedgerEntry, ok *int) {
+		defer wg.Done()
+		<-start
+		err := repo.PostLedgerEntry(ctx, entry, nil)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == nil:
+			*ok++
+		case errors.Is(err, models.ErrInsufficientFunds):
+			rejected++
+		default:
+			unexpected = append(unexpected, err)
+		}
+	}
+	for i := range deposits {
+		wg.Add(1)
+		go post(transfer(models.LedgerEntryDeposit, fmt.Sprintf("deposit:%d", i), userID, models.LedgerAccountDeposits, deposit), &deposited)
+	}
+	for i := range withdrawals {
+		wg.Add(1)
+		go post(transfer(models.LedgerEntryWithdrawal, fmt.Sprintf("withdrawal:%d", i), userID, models.LedgerAccountPayouts, -withdrawal), &withdrawn)
+	}
+	close(start)
+	wg.Wait()
+
+	for _, err := range unexpected {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if deposited != deposits {
+		t.Errorf("posted %d deposits, want %d", deposited, deposits)
+	}
+	if withdrawn+rejected != withdrawals {
+		t.Errorf("posted %d and rejected %d withdrawals, want %d in total", withdrawn, rejected, withdrawals)
+	}
+	// Денег хватает самое большее на (10000 + 50*1000) / 3000 = 20 списаний
+	if rejected == 0 {
+		t.Errorf("no withdrawal was rejected, overdraft guard did not trigger")
+	}
+
+	var user models.User
+	if err := db.First(&user, "telegram_id = ?", userID).Error; err != nil {
+		t.Fatalf("failed to reload user: %v", err)
+	}
+	want := initial + money.Amount(deposited)*deposit - money.Amount(withdrawn)*withdrawal
+	if user.Balance != want {
+		t.Errorf("balance = %s, want %s from %d deposits and %d withdrawals", user.Balance, want, deposited, withdrawn)
+	}
+	if ledger := userLedgerSum(t, db, userID); user.Balance != ledger {
+		t.Errorf("balance = %s, ledger sum = %s", user.Balance, ledger)
+	}
+	if user.Balance < 0 {
+		t.Errorf("balance went negative: %s", user.Balance)
+	}
+}
+
+// TestPostLedgerEntryConcurrentReplay проводит одну и ту же запись параллельно: применяться она должна ровно один раз.
+func TestPostLedgerEntryConcurrentReplay(t *testing.T) {
+	repo, db := testRepository(t)
+	ctx := context.Background()
+
+	const (
+		userID  int64        = 1002
+		attempt              = 20
+		deposit money.Amount = 1000
+	)
+	if err := db.Create(&models.User{TelegramID: userID, Currency: "RUB"}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	var (
+		wg              sync.WaitGroup
+		mu              sync.Mutex
+		posted, skipped int
+	)
+	start := make(chan struct{})
+	for range attempt {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := repo.PostLedgerEntry(ctx, transfer(models.LedgerEntryDeposit, "deposit:replay", userID, models.LedgerAccountDeposits, deposit), nil)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				posted++
+			case errors.Is(err, models.ErrAlreadyPosted):
+				skipped++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if posted != 1 || skipped != attempt-1 {
+		t.Errorf("posted %d, skipped %d, want 1 and %d", posted, skipped, attempt-1)
+	}
+
+	var user models.User
+	if err := db.First(&user, "telegram_id = ?", userID).Error; err != nil {
+		t.Fatalf("failed to reload user: %v", err)
+	}
+	if user.Balance != deposit {
+		t.Errorf("balance = %s, want %s", user.Balance, deposit)
+	}
+	if ledger := userLedgerSum(t, db, userID); ledger != deposit {
+		t.Errorf("ledger sum = %s, want %s", ledger, deposit)
+	}
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"gorm.io/gorm"
+)
+
+func (r *Repository) GetWithdrawalLimitOverride(ctx context.Context, userID int64) (*models.WithdrawalLimitOverride, error) {
+	var override models.WithdrawalLimitOverride
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&override).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get withdrawal limits of user %d: %w", userID, err)
+	}
+	return &override, nil
+}
+
+func (r *Repository) SaveWithdrawalLimitOverride(ctx context.Context, override *models.WithdrawalLimitOverride) error {
+	if err := r.db.WithContext(ctx).Save(override).Error; err != nil {
+		return fmt.Errorf("failed to save withdrawal limits of user %d: %w", override.UserID, err)
+	}
+	return nil
+}
+
+func (r *Repository) DeleteWithdrawalLimitOverride(ctx context.Context, userID int64) error {
+	err := r.db.WithContext(ctx).Delete(&models.WithdrawalLimitOverride{}, "user_id = ?", userID).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete withdrawal limits of user %d: %w", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"gorm.io/gorm"
+)
+
+// CreateQuote отменяет действующие котировки пользователя и сохраняет новую.
+func (r *Repository) CreateQuote(ctx context.Context, quote *models.Quote) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Quote{}).
+			Where("user_id = ? AND status = ?", quote.UserID, models.QuoteStatusActive).
+			Update("status", models.QuoteStatusCanceled).
+			Error
+		if err != nil {
+			return fmt.Errorf("failed to cancel previous quotes: %w", err)
+		}
+
+		if err := tx.Create(quote).Error; err != nil {
+			return fmt.Errorf("failed to create quote: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *Repository) GetActiveQuote(ctx context.Context, userID int64) (*models.Quote, error) {
+	var quote models.Quote
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND status = ?", userID, models.QuoteStatusActive).
+		Order("created_at DESC").
+		First(&quote).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active quote of user %d: %w", userID, err)
+	}
+	return &quote, nil
+}
+
+func (r *Repository) MarkQuoteUsed(ctx context.Context, id uint, txID string, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	err := db.WithContext(ctx).
+		Model(&models.Quote{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": models.QuoteStatusUsed, "tx_id": txID}).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("failed to mark quote #%d as used: %w", id, err)
+	}
+	return nil
+}
+
+func (r *Repository) CancelActiveQuotes(ctx context.Context, userID int64) error {
+	err := r.db.WithContext(ctx).
+		Model(&models.Quote{}).
+		Where("user_id = ? AND status = ?", userID, models.QuoteStatusActive).
+		Update("status", models.QuoteStatusCanceled).
+		Error
+
+	if err != nil {
+		return fmt.Errorf("failed to cancel quotes of user %d: %w", userID, err)
+	}
+	return nil
+}
@@ -6,13 +6,17 @@ import (
 	"fmt"
 
 	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
 	"gorm.io/gorm"
 )
 
-func (r *Repository) GetTransaction(ctx context.Context, txID string) (*models.Transaction, error) {
+// GetTransaction возвращает пополнение выходом txID:vout. Если транзакция учтена
+// до перехода на учёт по выходам, возвращается её общая запись.
+func (r *Repository) GetTransaction(ctx context.Context, txID string, vout int64) (*models.Transaction, error) {
 	var tx models.Transaction
 	err := r.db.WithContext(ctx).
-		Where("tx_id = ?", txID).
+		Where("tx_id = ? AND vout IN ?", txID, []int64{vout, models.TransactionLegacyVout}).
+		Order("vout DESC").
 		First(&tx).
 		Error
 
@@ -26,16 +30,87 @@ func (r *Repository) GetTransaction(ctx context.Context, txID string) (*models.T
 	return &tx, nil
 }
 
-func (r *Repository) CreateOrUpdateTransaction(ctx context.Context, tx *models.Transaction) error {
+func (r *Repository) CreateOrUpdateTransaction(ctx context.Context, tx *models.Transaction, dbTx *gorm.DB) error {
+	db := dbTx
+	if dbTx == nil {
+		db = r.db
+	}
+
 	var existing models.Transaction
-	err := r.db.WithContext(ctx).Where("tx_id = ?", tx.TxID).First(&existing).Error
+	err := db.WithContext(ctx).Where("tx_id = ? AND vout = ?", tx.TxID, tx.Vout).First(&existing).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return r.db.WithContext(ctx).Create(tx).Error
+			return db.WithContext(ctx).Create(tx).Error
 		}
 		return err
 	}
 
-	return r.db.WithContext(ctx).Model(&existing).Updates(tx).Error
+	// Условие по ключу задаётся явно: gorm не добавляет в WHERE нулевой vout,
+	// и без него обновление задело бы все выходы транзакции
+	return db.WithContext(ctx).
+		Model(&models.Transaction{}).
+		Where("tx_id = ? AND vout = ?", tx.TxID, tx.Vout).
+		Omit("tx_id", "vout").
+		Updates(tx).
+		Error
+}
+
+func (r *Repository) GetTransactionsByUser(ctx context.Context, userID int64, limit int) ([]models.Transaction, error) {
+	var txs []models.Transaction
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&txs).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions of user %d: %w", userID, err)
+	}
+	return txs, nil
+}
+
+func (r *Repository) GetTransactionsByStatus(ctx context.Context, userID int64, status string) ([]models.Transaction, error) {
+	var txs []models.Transaction
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND status = ?", userID, status).
+		Order("created_at ASC").
+		Find(&txs).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s transactions of user %d: %w", status, userID, err)
+	}
+	return txs, nil
+}
+
+func (r *Repository) GetAllTransactionsByStatuses(ctx context.Context, statuses ...string) ([]models.Transaction, error) {
+	var txs []models.Transaction
+	err := r.db.WithContext(ctx).
+		Where("status IN ?", statuses).
+		Order("user_id, created_at ASC").
+		Find(&txs).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions by statuses %v: %w", statuses, err)
+	}
+	return txs, nil
+}
+
+// SumCreditedFiat возвращает оборот пользователя — сумму зачисленных пополнений в валюте.
+func (r *Repository) SumCreditedFiat(ctx context.Context, userID int64, currency string) (money.Amount, error) {
+	var sum money.Amount
+	err := r.db.WithContext(ctx).
+		Model(&models.Transaction{}).
+		Where("user_id = ? AND status = ? AND currency = ?", userID, models.TransactionStatusCredited, currency).
+		Select("COALESCE(SUM(amount_fiat),0)").
+		Scan(&sum).
+		Error
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum credited deposits of user %d: %w", userID, err)
+	}
+	return sum, nil
 }
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+)
+
+// TestCreateOrUpdateTransactionOutputs обновляет выход vout 0 транзакции, у которой есть и другие выходы:
+// остальные записи той же транзакции меняться не должны.
+func TestCreateOrUpdateTransactionOutputs(t *testing.T) {
+	repo, db := testRepository(t)
+	ctx := context.Background()
+
+	const txID = "f00d"
+	outputs := []models.Transaction{
+		{TxID: txID, Vout: 0, UserID: 1, Address: "addr-0", AmountSats: 1000, Status: models.TransactionStatusConfirming},
+		{TxID: txID, Vout: 1, UserID: 2, Address: "addr-1", AmountSats: 2000, Status: models.TransactionStatusConfirming},
+		{TxID: txID, Vout: models.TransactionLegacyVout, UserID: 3, Address: "addr-legacy", AmountSats: 3000, Status: models.TransactionStatusConfirming},
+	}
+	for i := range outputs {
+		if err := repo.CreateOrUpdateTransaction(ctx, &outputs[i], nil); err != nil {
+			t.Fatalf("failed to create output %d: %v", outputs[i].Vout, err)
+		}
+	}
+
+	update := outputs[0]
+	update.Status = models.TransactionStatusCredited
+	update.Confirmations = 3
+	if err := repo.CreateOrUpdateTransaction(ctx, &update, nil); err != nil {
+		t.Fatalf("failed to update output 0: %v", err)
+	}
+
+	var stored []models.Transaction
+	if err := db.Where("tx_id = ?", txID).Order("vout").Find(&stored).Error; err != nil {
+		t.Fatalf("failed to load outputs: %v", err)
+	}
+	if len(stored) != len(outputs) {
+		t.Fatalf("found %d outputs, want %d", len(stored), len(outputs))
+	}
+	for _, got := range stored {
+		want := outputs[0]
+		for _, output := range outputs {
+			if output.Vout == got.Vout {
+				want = output
+			}
+		}
+		if got.Vout == 0 {
+			want = update
+		}
+		if got.UserID != want.UserID || got.Address != want.Address || got.AmountSats != want.AmountSats ||
+			got.Status != want.Status || got.Confirmations != want.Confirmations {
+			t.Errorf("output %d = user %d, %s, %d sats, %s, %d confirmations; want user %d, %s, %d sats, %s, %d confirmations",
+				got.Vout, got.UserID, got.Address, got.AmountSats, got.Status, got.Confirmations,
+				want.UserID, want.Address, want.AmountSats, want.Status, want.Confirmations)
+		}
+	}
+}
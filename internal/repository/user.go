@@ -3,9 +3,12 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/Fi44er/btc_bot/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (r *Repository) GetUser(ctx context.Context, telegramID int64) (*models.User, error) {
@@ -20,15 +23,106 @@ func (r *Repository) GetUser(ctx context.Context, telegramID int64) (*models.Use
 	return &user, nil
 }
 
+// LockUser блокирует пользователя до конца транзакции tx и возвращает его.
+func (r *Repository) LockUser(ctx context.Context, telegramID int64, tx *gorm.DB) (*models.User, error) {
+	var user models.User
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&user, "telegram_id = ?", telegramID).
+		Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to lock user %d: %w", telegramID, err)
+	}
+	return &user, nil
+}
+
 func (r *Repository) CreateUser(ctx context.Context, user *models.User) error {
 	return r.db.WithContext(ctx).Create(user).Error
 }
 
-func (r *Repository) UpdateUser(ctx context.Context, user *models.User, tx *gorm.DB) error {
+// UpdateUserCard меняет номер карты и, если changedAt задан, время смены карты.
+// Остальные поля пользователя не трогаются: их меняют другие операции, а баланс — только проводки журнала.
+func (r *Repository) UpdateUserCard(ctx context.Context, telegramID int64, cardNumber string, changedAt *time.Time, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	fields := map[string]interface{}{"card_number": cardNumber}
+	if changedAt != nil {
+		fields["card_changed_at"] = *changedAt
+	}
+	res := db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("telegram_id = ?", telegramID).
+		Updates(fields)
+
+	if res.Error != nil {
+		return fmt.Errorf("failed to update card of user %d: %w", telegramID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("пользователь с telegram_id %d не найден", telegramID)
+	}
+	return nil
+}
+
+// SetUserWallet привязывает к пользователю адрес пополнения, если адреса у него ещё нет.
+func (r *Repository) SetUserWallet(ctx context.Context, telegramID, walletID int64, tx *gorm.DB) error {
 	db := tx
 	if tx == nil {
 		db = r.db
 	}
 
-	return db.WithContext(ctx).Save(user).Error
+	res := db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("telegram_id = ? AND system_wallet_id IS NULL", telegramID).
+		Update("system_wallet_id", walletID)
+
+	if res.Error != nil {
+		return fmt.Errorf("failed to set wallet of user %d: %w", telegramID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("у пользователя %d уже есть адрес пополнения", telegramID)
+	}
+	return nil
+}
+
+func (r *Repository) SetUserVIP(ctx context.Context, telegramID int64, vip bool) error {
+	tx := r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("telegram_id = ?", telegramID).
+		Update("is_vip", vip)
+
+	if tx.Error != nil {
+		return fmt.Errorf("failed to update VIP flag of user %d: %w", telegramID, tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("пользователь с telegram_id %d не найден", telegramID)
+	}
+	return nil
+}
+
+// UpdateUserCurrency меняет валюту только при нулевом балансе, чтобы не потерять
+// зачисление, пришедшее после проверки баланса.
+func (r *Repository) UpdateUserCurrency(ctx context.Context, telegramID int64, currency string, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+
+	res := db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("telegram_id = ? AND balance = 0", telegramID).
+		Update("currency", currency)
+
+	if res.Error != nil {
+		return fmt.Errorf("failed to update currency of user %d: %w", telegramID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("сменить валюту можно только при нулевом балансе")
+	}
+	return nil
 }
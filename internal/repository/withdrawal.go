@@ -7,6 +7,7 @@ import (
 
 	"github.com/Fi44er/btc_bot/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (r *Repository) GetAllWithdrawals(ctx context.Context) ([]models.Withdrawal, error) {
@@ -18,20 +19,11 @@ func (r *Repository) GetAllWithdrawals(ctx context.Context) ([]models.Withdrawal
 	return withdrawals, nil
 }
 
-func (r *Repository) SumPendingWithdrawals(ctx context.Context, userID int64) (float64, error) {
-	var sum float64
-	err := r.db.WithContext(ctx).
-		Model(&models.Withdrawal{}).
-		Where("user_id = ? AND status = ?", userID, "pending").
-		Select("COALESCE(SUM(amount),0)").Scan(&sum).Error
-	return sum, err
-}
-
-// Получает существующую заявку пользователя со статусом pending
+// Получает незавершённую заявку пользователя
 func (r *Repository) GetPendingWithdrawalByUser(ctx context.Context, userID int64) (*models.Withdrawal, error) {
 	var withdrawal models.Withdrawal
 	err := r.db.WithContext(ctx).
-		Where("user_id = ? AND status = ?", userID, "pending").
+		Where("user_id = ? AND status IN ?", userID, models.WithdrawalOpenStatuses).
 		First(&withdrawal).Error
 	if err == gorm.ErrRecordNotFound {
 		return nil, nil
@@ -43,19 +35,28 @@ func (r *Repository) GetPendingWithdrawalByUser(ctx context.Context, userID int6
 }
 
 // Обновить заявку
-func (r *Repository) UpdateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error {
-	return r.db.WithContext(ctx).Save(withdrawal).Error
+func (r *Repository) UpdateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+	return db.WithContext(ctx).Save(withdrawal).Error
 }
 
-func (r *Repository) CreateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error {
-	return r.db.WithContext(ctx).Create(withdrawal).Error
+func (r *Repository) CreateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
+	}
+	return db.WithContext(ctx).Create(withdrawal).Error
 }
 
+// GetPendingWithdrawals возвращает все незавершённые заявки, от старых к новым.
 func (r *Repository) GetPendingWithdrawals(ctx context.Context) ([]*models.Withdrawal, error) {
 	var withdrawals []*models.Withdrawal
 	err := r.db.WithContext(ctx).
-		Where("status = ?", "pending").
-		Order("created_at ASC").
+		Where("status IN ?", models.WithdrawalOpenStatuses).
+		Order("id ASC").
 		Find(&withdrawals).
 		Error
 
@@ -81,24 +82,29 @@ func (r *Repository) GetWithdrawalByID(ctx context.Context, id int64) (*models.W
 	return &withdrawal, nil
 }
 
-func (r *Repository) UpdateWithdrawalStatus(ctx context.Context, id int64, status string) error {
-	err := r.db.WithContext(ctx).
-		Model(&models.Withdrawal{}).
+// LockWithdrawal читает заявку с блокировкой строки до конца транзакции tx.
+func (r *Repository) LockWithdrawal(ctx context.Context, id int64, tx *gorm.DB) (*models.Withdrawal, error) {
+	var withdrawal models.Withdrawal
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
 		Where("id = ?", id).
-		Update("status", status).
+		First(&withdrawal).
 		Error
 
 	if err != nil {
-		return fmt.Errorf("failed to update withdrawal status: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to lock withdrawal %d: %w", id, err)
 	}
-	return nil
+	return &withdrawal, nil
 }
 
 func (r *Repository) GetPendingWithdrawalByUserID(ctx context.Context, userID int64) (*models.Withdrawal, error) {
 	var withdrawal models.Withdrawal
 
 	err := r.db.WithContext(ctx).
-		Where("user_id = ? AND status = ?", userID, "pending").
+		Where("user_id = ? AND status IN ?", userID, models.WithdrawalOpenStatuses).
 		First(&withdrawal).
 		Error
 
@@ -129,21 +135,45 @@ func (r *Repository) DeleteWithdrawal(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (r *Repository) UpdateUserBalance(ctx context.Context, userID int64, newBalance float64) error {
-	tx := r.db.WithContext(ctx).
-		Model(&models.User{}).
-		Where("telegram_id = ?", userID).
-		Update("balance", newBalance)
+// GetWithdrawalsByUser возвращает последние limit заявок пользователя, от новых к старым.
+func (r *Repository) GetWithdrawalsByUser(ctx context.Context, userID int64, limit int) ([]models.Withdrawal, error) {
+	var withdrawals []models.Withdrawal
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&withdrawals).
+		Error
 
-	if tx.Error != nil {
-		r.logger.Errorf("ошибка обновления баланса для пользователя %d в БД: %v", userID, tx.Error)
-		return fmt.Errorf("ошибка БД при обновлении баланса: %w", tx.Error)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get withdrawals of user %d: %w", userID, err)
 	}
+	return withdrawals, nil
+}
 
-	if tx.RowsAffected == 0 {
-		return fmt.Errorf("пользователь с telegram_id %d не найден для обновления баланса", userID)
+func (r *Repository) CreateWithdrawalTransition(ctx context.Context, transition *models.WithdrawalTransition, tx *gorm.DB) error {
+	db := tx
+	if tx == nil {
+		db = r.db
 	}
 
-	r.logger.Infof("Баланс пользователя %d обновлен на %.8f", userID, newBalance)
+	if err := db.WithContext(ctx).Create(transition).Error; err != nil {
+		return fmt.Errorf("failed to save transition of withdrawal %d: %w", transition.WithdrawalID, err)
+	}
 	return nil
 }
+
+// GetWithdrawalTransitions возвращает историю статусов заявки по порядку.
+func (r *Repository) GetWithdrawalTransitions(ctx context.Context, withdrawalID uint) ([]models.WithdrawalTransition, error) {
+	var transitions []models.WithdrawalTransition
+	err := r.db.WithContext(ctx).
+		Where("withdrawal_id = ?", withdrawalID).
+		Order("id ASC").
+		Find(&transitions).
+		Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transitions of withdrawal %d: %w", withdrawalID, err)
+	}
+	return transitions, nil
+}
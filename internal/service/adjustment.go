@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+// AdjustBalance вручную меняет баланс пользователя на amount по решению администратора adminID.
+// Проводка по журналу и запись аудита с остатками до и после делаются в одной транзакции БД;
+// списание больше остатка отклоняется с models.ErrInsufficientFunds. Сумма задана в валюте currency:
+// если пользователь с тех пор сменил валюту, корректировка не применяется.
+func (s *Service) AdjustBalance(ctx context.Context, adminID, userID int64, amount money.Amount, currency, reason string) (*models.BalanceAdjustment, error) {
+	if amount == 0 {
+		return nil, errors.New("сумма корректировки не может быть нулевой")
+	}
+	if reason == "" {
+		return nil, errors.New("не указана причина корректировки")
+	}
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.repo.Rollback(tx)
+		}
+	}()
+
+	user, err := s.repo.LockUser(ctx, userID, s.repo.WithTransaction(tx))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("пользователь с telegram_id %d не найден", userID)
+	}
+	if user.Currency != currency {
+		return nil, fmt.Errorf("сумма указана в %s, а баланс пользователя теперь в %s", currency, user.Currency)
+	}
+
+	entry := userEntry(models.LedgerEntryAdjustment, user, amount, models.LedgerAccountAdjustments,
+		fmt.Sprintf("корректировка администратором %d: %s", adminID, reason))
+	if err := s.postEntry(ctx, entry, s.repo.WithTransaction(tx)); err != nil {
+		return nil, err
+	}
+
+	// Пользователь заблокирован, поэтому других изменений баланса между чтением и проводкой не было
+	adjustment := &models.BalanceAdjustment{
+		UserID:        userID,
+		AdminID:       adminID,
+		Amount:        amount,
+		Currency:      user.Currency,
+		Reason:        reason,
+		BalanceBefore: user.Balance,
+		BalanceAfter:  user.Balance + amount,
+		LedgerEntryID: entry.ID,
+	}
+	if err := s.repo.CreateBalanceAdjustment(ctx, adjustment, s.repo.WithTransaction(tx)); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Commit(tx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+
+	s.logger.Infof("Admin %d adjusted balance of user %d by %s %s: %s -> %s (%s)",
+		adminID, userID, amount, adjustment.Currency, adjustment.BalanceBefore, adjustment.BalanceAfter, reason)
+	return adjustment, nil
+}
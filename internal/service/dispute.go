@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"gorm.io/gorm"
+)
+
+// ErrDisputeOpen — по операции открыт спор, и она заморожена до его решения.
+var ErrDisputeOpen = errors.New("по операции открыт спор")
+
+// OpenDispute открывает спор пользователя по пополнению (TransactionID и Vout) или заявке на вывод
+// (WithdrawalID) из dispute. По одной операции может быть открыт только один спор.
+func (s *Service) OpenDispute(ctx context.Context, dispute *models.Dispute) (*models.Dispute, error) {
+	dispute.Reason = strings.TrimSpace(dispute.Reason)
+	if dispute.Reason == "" {
+		return nil, errors.New("опишите, что не так с операцией")
+	}
+	if (dispute.WithdrawalID == nil) == (dispute.TransactionID == nil || dispute.Vout == nil) {
+		return nil, errors.New("спор должен относиться к одному пополнению или одной заявке на вывод")
+	}
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.repo.Rollback(tx)
+		}
+	}()
+
+	if dispute.WithdrawalID != nil {
+		// Блокировка заявки упорядочивает открытие спора с переходами её статуса
+		withdrawal, err := s.repo.LockWithdrawal(ctx, int64(*dispute.WithdrawalID), tx)
+		if err != nil {
+			return nil, err
+		}
+		if withdrawal == nil || withdrawal.UserID != dispute.UserID {
+			return nil, errors.New("заявка на вывод не найдена")
+		}
+		existing, err := s.repo.GetOpenWithdrawalDispute(ctx, withdrawal.ID, s.repo.WithTransaction(tx))
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return nil, fmt.Errorf("%w: по заявке #%d уже открыт спор #%d", ErrDisputeOpen, withdrawal.ID, existing.ID)
+		}
+	} else {
+		deposit, err := s.repo.GetTransaction(ctx, *dispute.TransactionID, *dispute.Vout)
+		if err != nil {
+			return nil, err
+		}
+		if deposit == nil || deposit.UserID != dispute.UserID {
+			return nil, errors.New("пополнение не найдено")
+		}
+		disputed, err := s.repo.GetOpenDepositDisputes(ctx, dispute.UserID, s.repo.WithTransaction(tx))
+		if err != nil {
+			return nil, err
+		}
+		if disputed[deposit.Outpoint()] {
+			return nil, fmt.Errorf("%w: по пополнению %s уже открыт спор", ErrDisputeOpen, deposit.Outpoint())
+		}
+	}
+
+	// Спор решается в текущей валюте баланса: пока он открыт, сменить её нельзя (см. SetUserCurrency).
+	// Блокировка пользователя — после блокировки заявки, как и при её проведении
+	user, err := s.repo.LockUser(ctx, dispute.UserID, s.repo.WithTransaction(tx))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("пользователь не найден")
+	}
+	dispute.Currency = user.Currency
+
+	dispute.Status = models.DisputeStatusOpen
+	if err := s.repo.CreateDispute(ctx, dispute, s.repo.WithTransaction(tx)); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Commit(tx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+
+	s.logger.Infof("User %d opened dispute #%d on %s", dispute.UserID, dispute.ID, dispute.Subject())
+	return dispute, nil
+}
+
+func (s *Service) GetDispute(ctx context.Context, id int64) (*models.Dispute, error) {
+	return s.repo.GetDisputeByID(ctx, id)
+}
+
+func (s *Service) GetOpenDisputes(ctx context.Context) ([]models.Dispute, error) {
+	return s.repo.GetOpenDisputes(ctx)
+}
+
+func (s *Service) GetUserDisputes(ctx context.Context, userID int64, limit int) ([]models.Dispute, error) {
+	return s.repo.GetDisputesByUser(ctx, userID, limit)
+}
+
+func (s *Service) GetUserWithdrawals(ctx context.Context, userID int64, limit int) ([]models.Withdrawal, error) {
+	return s.repo.GetWithdrawalsByUser(ctx, userID, limit)
+}
+
+func (s *Service) GetDisputeMessages(ctx context.Context, disputeID uint) ([]models.DisputeMessage, error) {
+	return s.repo.GetDisputeMessages(ctx, disputeID)
+}
+
+// AddDisputeMessage сохраняет сообщение senderID в переписке по открытому спору и возвращает спор,
+// чтобы бот переслал сообщение другой стороне. Пользователь может писать только по своему спору.
+func (s *Service) AddDisputeMessage(ctx context.Context, disputeID int64, senderID int64, fromAdmin bool, text string) (*models.Dispute, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("сообщение пустое")
+	}
+
+	dispute, err := s.repo.GetDisputeByID(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if dispute == nil || (!fromAdmin && dispute.UserID != senderID) {
+		return nil, errors.New("спор не найден")
+	}
+	if dispute.Status != models.DisputeStatusOpen {
+		return nil, fmt.Errorf("спор #%d уже закрыт", dispute.ID)
+	}
+
+	message := &models.DisputeMessage{
+		DisputeID: dispute.ID,
+		SenderID:  senderID,
+		FromAdmin: fromAdmin,
+		Text:      text,
+	}
+	if err := s.repo.CreateDisputeMessage(ctx, message); err != nil {
+		return nil, err
+	}
+	return dispute, nil
+}
+
+// ResolveDispute закрывает открытый спор решением администратора adminID и проводит его по журналу
+// в той же транзакции БД:
+//   - DisputeStatusRejected — претензия отклонена, баланс не меняется;
+//   - DisputeStatusAdjusted — баланс меняется на amount (со знаком) корректировкой;
+//   - DisputeStatusAccepted — по незавершённой заявке резерв возвращается и заявка отменяется,
+//     по завершённой сумма с комиссией сторнируется на баланс; по пополнению на баланс зачисляется
+//     amount, а ещё не зачисленное пополнение помечается зачисленным на эту сумму.
+func (s *Service) ResolveDispute(ctx context.Context, adminID, disputeID int64, status string, amount money.Amount, comment string) (*models.Dispute, error) {
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.repo.Rollback(tx)
+		}
+	}()
+
+	dispute, err := s.repo.LockDispute(ctx, disputeID, tx)
+	if err != nil {
+		return nil, err
+	}
+	if dispute == nil {
+		return nil, errors.New("спор не найден")
+	}
+	if dispute.Status != models.DisputeStatusOpen {
+		return nil, fmt.Errorf("спор #%d уже закрыт", dispute.ID)
+	}
+
+	var entry *models.LedgerEntry
+	switch status {
+	case models.DisputeStatusRejected:
+		amount = 0
+	case models.DisputeStatusAdjusted:
+		if amount == 0 {
+			return nil, errors.New("сумма корректировки не может быть нулевой")
+		}
+		entry, err = s.adjustForDispute(ctx, tx, dispute, amount)
+	case models.DisputeStatusAccepted:
+		if dispute.WithdrawalID != nil {
+			entry, amount, err = s.refundDisputedWithdrawal(ctx, tx, adminID, dispute)
+		} else {
+			if amount <= 0 {
+				return nil, errors.New("укажите сумму, которую нужно зачислить по пополнению")
+			}
+			entry, err = s.creditDisputedDeposit(ctx, tx, dispute, amount)
+		}
+	default:
+		return nil, errors.New("invalid dispute resolution")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	dispute.Status = status
+	dispute.Amount = amount
+	dispute.Resolution = strings.TrimSpace(comment)
+	dispute.ResolvedBy = adminID
+	dispute.ResolvedAt = &now
+	if entry != nil {
+		dispute.LedgerEntryID = &entry.ID
+	}
+	if err := s.repo.UpdateDispute(ctx, dispute, s.repo.WithTransaction(tx)); err != nil {
+		return nil, fmt.Errorf("failed to update dispute: %w", err)
+	}
+
+	if err := s.repo.Commit(tx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+
+	s.logger.Infof("Admin %d resolved dispute #%d of user %d as %s (%s %s)",
+		adminID, dispute.ID, dispute.UserID, status, amount, dispute.Currency)
+	return dispute, nil
+}
+
+// disputeEntry привязывает запись журнала к спору и к его предмету.
+func disputeEntry(entry *models.LedgerEntry, dispute *models.Dispute) *models.LedgerEntry {
+	key := fmt.Sprintf("dispute:%d", dispute.ID)
+	entry.Key = &key
+	entry.TransactionID = dispute.TransactionID
+	entry.WithdrawalID = dispute.WithdrawalID
+	return entry
+}
+
+func (s *Service) lockDisputeUser(ctx context.Context, tx *gorm.DB, dispute *models.Dispute) (*models.User, error) {
+	user, err := s.repo.LockUser(ctx, dispute.UserID, s.repo.WithTransaction(tx))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("пользователь с telegram_id %d не найден", dispute.UserID)
+	}
+	// Проводки по спору идут в валюте баланса. Она могла смениться только у споров, открытых
+	// до запрета смены валюты при открытом споре: их закрывают отказом и корректировкой /adjust
+	if user.Currency != dispute.Currency {
+		return nil, fmt.Errorf("спор #%d в %s, а баланс пользователя теперь в %s: закройте спор отказом и скорректируйте баланс в %s",
+			dispute.ID, dispute.Currency, user.Currency, user.Currency)
+	}
+	return user, nil
+}
+
+func (s *Service) adjustForDispute(ctx context.Context, tx *gorm.DB, dispute *models.Dispute, amount money.Amount) (*models.LedgerEntry, error) {
+	user, err := s.lockDisputeUser(ctx, tx, dispute)
+	if err != nil {
+		return nil, err
+	}
+	entry := disputeEntry(userEntry(models.LedgerEntryAdjustment, user, amount, models.LedgerAccountAdjustments,
+		fmt.Sprintf("корректировка по спору #%d (%s)", dispute.ID, dispute.Subject())), dispute)
+	if err := s.postEntry(ctx, entry, s.repo.WithTransaction(tx)); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// refundDisputedWithdrawal возвращает пользователю деньги по спорной заявке и сумму возврата.
+func (s *Service) refundDisputedWithdrawal(ctx context.Context, tx *gorm.DB, adminID int64, dispute *models.Dispute) (*models.LedgerEntry, money.Amount, error) {
+	withdrawal, err := s.repo.LockWithdrawal(ctx, int64(*dispute.WithdrawalID), tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if withdrawal == nil {
+		return nil, 0, errors.New("withdrawal not found")
+	}
+
+	switch withdrawal.Status {
+	case models.WithdrawalStatusCanceled:
+		return nil, 0, fmt.Errorf("%w: заявка #%d уже отменена, возвращать нечего", ErrWithdrawalStatus, withdrawal.ID)
+	case models.WithdrawalStatusCompleted:
+		// Выплата в прежней валюте не возвращается как есть: сумму в текущей валюте назначает администратор
+		if withdrawal.Currency != dispute.Currency {
+			return nil, 0, fmt.Errorf("заявка #%d выплачена в %s, а спор — в %s: решите его корректировкой adjust на сумму в %s",
+				withdrawal.ID, withdrawal.Currency, dispute.Currency, dispute.Currency)
+		}
+		if _, err := s.lockDisputeUser(ctx, tx, dispute); err != nil {
+			return nil, 0, err
+		}
+		refund := withdrawal.Amount + withdrawal.Fee
+		postings := []models.LedgerPosting{
+			withdrawalPosting(models.LedgerAccountUser, withdrawal, refund),
+			withdrawalPosting(models.LedgerAccountPayouts, withdrawal, -withdrawal.Amount),
+		}
+		if withdrawal.Fee > 0 {
+			postings = append(postings, withdrawalPosting(models.LedgerAccountFees, withdrawal, -withdrawal.Fee))
+		}
+		entry := disputeEntry(withdrawalEntry(models.LedgerEntryReversal, withdrawal, "",
+			fmt.Sprintf("возврат вывода #%d по спору #%d", withdrawal.ID, dispute.ID), postings...), dispute)
+		if err := s.postEntry(ctx, entry, s.repo.WithTransaction(tx)); err != nil {
+			return nil, 0, err
+		}
+		return entry, refund, nil
+	default:
+		// Незавершённая заявка отменяется, а её резерв возвращается на баланс. Принятый спор по записанному
+		// переводу подтверждает, что перевода не было
+		var released []*models.LedgerEntry
+		var refund money.Amount
+		actor := adminActor(adminID)
+		actor.comment = fmt.Sprintf("спор #%d", dispute.ID)
+		if slices.Contains(models.WithdrawalVoidableStatuses, withdrawal.Status) {
+			actor.comment += ": перевода не было"
+			actor.voidsPayout = true
+		}
+		err := s.applyWithdrawalMove(ctx, tx, withdrawal, models.WithdrawalStatusCanceled, actor, nil,
+			func(withdrawal *models.Withdrawal, held money.Amount) []*models.LedgerEntry {
+				refund = held
+				released = voidWithdrawal(actor.comment)(withdrawal, held)
+				return released
+			})
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(released) == 0 {
+			return nil, 0, nil
+		}
+		return released[0], refund, nil
+	}
+}
+
+// creditDisputedDeposit зачисляет amount по спорному пополнению. Ещё не зачисленное пополнение
+// проводится под своим обычным ключом, поэтому регулярная проверка не зачислит его повторно.
+func (s *Service) creditDisputedDeposit(ctx context.Context, tx *gorm.DB, dispute *models.Dispute, amount money.Amount) (*models.LedgerEntry, error) {
+	deposit, err := s.repo.GetTransaction(ctx, *dispute.TransactionID, *dispute.Vout)
+	if err != nil {
+		return nil, err
+	}
+	if deposit == nil {
+		return nil, errors.New("пополнение не найдено")
+	}
+	user, err := s.lockDisputeUser(ctx, tx, dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := userEntry(models.LedgerEntryDeposit, user, amount, models.LedgerAccountDeposits,
+		fmt.Sprintf("зачисление пополнения %s по спору #%d", deposit.Outpoint(), dispute.ID))
+	if deposit.Status == models.TransactionStatusCredited {
+		entry = disputeEntry(entry, dispute)
+	} else {
+		key := "deposit:" + deposit.Outpoint()
+		entry.TransactionID = &deposit.TxID
+		entry.Key = &key
+	}
+	if err := s.postEntry(ctx, entry, s.repo.WithTransaction(tx)); err != nil {
+		return nil, err
+	}
+
+	if deposit.Status != models.TransactionStatusCredited {
+		now := time.Now()
+		deposit.Status = models.TransactionStatusCredited
+		deposit.AmountFiat = amount
+		deposit.Currency = dispute.Currency
+		deposit.CreditedAt = &now
+		deposit.PriceTier = fmt.Sprintf("спор #%d", dispute.ID)
+		if err := s.repo.CreateOrUpdateTransaction(ctx, deposit, s.repo.WithTransaction(tx)); err != nil {
+			return nil, fmt.Errorf("failed to mark deposit as credited: %w", err)
+		}
+	}
+	return entry, nil
+}
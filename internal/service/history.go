@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+)
+
+const historyPageSize = 5
+
+// GetHistory возвращает страницу истории пользователя, от новых операций к старым:
+// пополнения, незавершённую заявку на вывод и остальные изменения баланса по журналу.
+func (s *Service) GetHistory(ctx context.Context, userID int64, page int) (*models.HistoryPage, error) {
+	items, err := s.history(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := max(1, (len(items)+historyPageSize-1)/historyPageSize)
+	page = min(max(page, 0), pages-1)
+
+	from := page * historyPageSize
+	to := min(from+historyPageSize, len(items))
+
+	return &models.HistoryPage{Items: items[from:to], Page: page, Pages: pages}, nil
+}
+
+func (s *Service) history(ctx context.Context, userID int64) ([]models.HistoryItem, error) {
+	var items []models.HistoryItem
+
+	deposits, err := s.repo.GetTransactionsByUser(ctx, userID, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range deposits {
+		items = append(items, models.HistoryItem{
+			Kind:       models.LedgerEntryDeposit,
+			Time:       tx.CreatedAt,
+			AmountSats: tx.AmountSats,
+			Rate:       tx.Rate,
+			Amount:     tx.AmountFiat,
+			Currency:   tx.Currency,
+			Status:     tx.Status,
+			TxID:       tx.TxID,
+		})
+	}
+
+	// Пополнения уже есть выше, начальные остатки — не операции пользователя,
+	// а резерв по заявке показывается самой заявкой ниже
+	entries, err := s.repo.GetLedgerEntriesByUser(ctx, userID,
+		models.LedgerEntryDeposit, models.LedgerEntryOpening, models.LedgerEntryHold, models.LedgerEntryRelease)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		item := models.HistoryItem{
+			Kind:        entry.Kind,
+			Time:        entry.CreatedAt,
+			Currency:    entry.Currency,
+			Description: entry.Description,
+		}
+		for _, posting := range entry.Postings {
+			item.Amount += posting.Amount
+		}
+		items = append(items, item)
+	}
+
+	pending, err := s.repo.GetPendingWithdrawalByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		items = append(items, models.HistoryItem{
+			Kind:     models.LedgerEntryWithdrawal,
+			Amount:   -pending.Amount,
+			Currency: pending.Currency,
+			Status:   pending.Status,
+		})
+	}
+
+	// Необработанная заявка без времени — самая свежая операция
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Time.IsZero() != items[j].Time.IsZero() {
+			return items[i].Time.IsZero()
+		}
+		return items[i].Time.After(items[j].Time)
+	})
+
+	return items, nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+// checkGroup не даёт запускать несколько проверок одного пользователя одновременно:
+// повторный вызов дожидается уже идущей проверки и получает её результат.
+type checkGroup struct {
+	mu    sync.Mutex
+	calls map[int64]*checkCall
+}
+
+type checkCall struct {
+	done   chan struct{}
+	amount money.Amount
+	err    error
+}
+
+func newCheckGroup() *checkGroup {
+	return &checkGroup{calls: make(map[int64]*checkCall)}
+}
+
+func (g *checkGroup) do(userID int64, fn func() (money.Amount, error)) (money.Amount, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[userID]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.amount, call.err
+	}
+
+	return g.start(userID, fn)
+}
+
+// exclusive дожидается окончания текущей проверки пользователя и выполняет fn;
+// проверки, начатые во время работы fn, присоединяются к ней.
+func (g *checkGroup) exclusive(userID int64, fn func() (money.Amount, error)) (money.Amount, error) {
+	g.mu.Lock()
+	for call, ok := g.calls[userID]; ok; call, ok = g.calls[userID] {
+		g.mu.Unlock()
+		<-call.done
+		g.mu.Lock()
+	}
+
+	return g.start(userID, fn)
+}
+
+// start регистрирует и выполняет проверку; вызывается с захваченным g.mu.
+func (g *checkGroup) start(userID int64, fn func() (money.Amount, error)) (money.Amount, error) {
+	call := &checkCall{done: make(chan struct{})}
+	g.calls[userID] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, userID)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.amount, call.err = fn()
+	return call.amount, call.err
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"gorm.io/gorm"
+)
+
+// userEntry собирает запись журнала, которая меняет баланс пользователя на amount
+// за счёт системного счёта counterAccount.
+func userEntry(kind string, user *models.User, amount money.Amount, counterAccount, description string) *models.LedgerEntry {
+	userID := user.TelegramID
+	return &models.LedgerEntry{
+		Kind:        kind,
+		Currency:    user.Currency,
+		Description: description,
+		Postings: []models.LedgerPosting{
+			{Account: models.LedgerAccountUser, UserID: &userID, Currency: user.Currency, Amount: amount},
+			{Account: counterAccount, Currency: user.Currency, Amount: -amount},
+		},
+	}
+}
+
+// postEntry проверяет, что запись сбалансирована, и проводит её по журналу и балансам.
+// tx — транзакция БД, в которой нужно сделать запись, либо nil.
+func (s *Service) postEntry(ctx context.Context, entry *models.LedgerEntry, tx *gorm.DB) error {
+	if len(entry.Postings) < 2 {
+		return errors.New("запись журнала должна содержать хотя бы две проводки")
+	}
+
+	var sum money.Amount
+	for _, posting := range entry.Postings {
+		if posting.Currency != entry.Currency {
+			return fmt.Errorf("проводка в %s не совпадает с валютой записи %s", posting.Currency, entry.Currency)
+		}
+		if posting.Account == models.LedgerAccountUser && posting.UserID == nil {
+			return errors.New("проводка по счёту пользователя без пользователя")
+		}
+		sum += posting.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("запись журнала не сбалансирована: %s %s", sum, entry.Currency)
+	}
+
+	if err := s.repo.PostLedgerEntry(ctx, entry, tx); err != nil {
+		return err
+	}
+
+	s.logger.Infof("Ledger entry #%d (%s) posted: %s", entry.ID, entry.Kind, entry.Description)
+	return nil
+}
+
+// RebuildBalances сверяет балансы всех пользователей с журналом и возвращает расхождения.
+// При fix балансы перезаписываются остатками по журналу.
+func (s *Service) RebuildBalances(ctx context.Context, fix bool) ([]models.BalanceDrift, error) {
+	userIDs, err := s.repo.GetAllUserIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []models.BalanceDrift
+	for _, userID := range userIDs {
+		drift, err := s.repo.RebuildUserBalance(ctx, userID, fix)
+		if err != nil {
+			return drifts, fmt.Errorf("не удалось пересчитать баланс пользователя %d: %w", userID, err)
+		}
+		if drift != nil {
+			s.logger.Warnf("Balance drift for user %d: stored %s, ledger %s %s", userID, drift.Stored, drift.Ledger, drift.Currency)
+			drifts = append(drifts, *drift)
+		}
+	}
+
+	return drifts, nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/limits"
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"gorm.io/gorm"
+)
+
+// ErrWithdrawalLimit — заявка на вывод нарушает лимиты.
+var ErrWithdrawalLimit = errors.New("вывод ограничен")
+
+// GetWithdrawalLimits возвращает действующие для пользователя лимиты на вывод и их использование.
+func (s *Service) GetWithdrawalLimits(ctx context.Context, userID int64) (*models.WithdrawalLimits, error) {
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("пользователь с telegram_id %d не найден", userID)
+	}
+	return s.withdrawalLimits(ctx, user, nil)
+}
+
+// withdrawalLimits собирает лимиты пользователя из настроек и назначенных администратором
+// и считает, сколько из них использовано за скользящие периоды.
+func (s *Service) withdrawalLimits(ctx context.Context, user *models.User, tx *gorm.DB) (*models.WithdrawalLimits, error) {
+	override, err := s.repo.GetWithdrawalLimitOverride(ctx, user.TelegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	configured := s.limits.For(user.Currency)
+	result := &models.WithdrawalLimits{
+		UserID:   user.TelegramID,
+		Currency: user.Currency,
+		Min:      configured.Min,
+		Max:      configured.Max,
+		Override: override,
+	}
+	userCaps := maps.Clone(configured.User)
+	if userCaps == nil {
+		userCaps = make(limits.Caps)
+	}
+	ignoreGlobal, ignoreCooldown := false, false
+	if override != nil {
+		// Суммы назначены в валюте, которая была у пользователя тогда; после смены валюты
+		// они значили бы другие деньги, поэтому действуют лимиты из настроек
+		if override.Currency == user.Currency {
+			if override.MinAmount != nil {
+				result.Min = *override.MinAmount
+			}
+			if override.MaxAmount != nil {
+				result.Max = *override.MaxAmount
+			}
+			for period, amount := range map[string]*money.Amount{"day": override.Daily, "week": override.Weekly, "month": override.Monthly} {
+				if amount != nil {
+					userCaps[period] = *amount
+				}
+			}
+		}
+		ignoreGlobal, ignoreCooldown = override.IgnoreGlobal, override.IgnoreCardCooldown
+	}
+
+	now := time.Now()
+	for _, period := range limits.Periods {
+		since := now.Add(-period.Duration)
+
+		if limit := userCaps[period.Name]; limit > 0 {
+			used, err := s.repo.SumWithdrawalHolds(ctx, user.TelegramID, user.Currency, since, tx)
+			if err != nil {
+				return nil, err
+			}
+			result.Usage = append(result.Usage, models.WithdrawalLimitUsage{
+				Period: period.Name, Title: period.Title, Scope: models.WithdrawalLimitScopeUser, Limit: limit, Used: used,
+			})
+		}
+
+		if limit := configured.Global[period.Name]; limit > 0 && !ignoreGlobal {
+			used, err := s.repo.SumWithdrawalHolds(ctx, 0, user.Currency, since, tx)
+			if err != nil {
+				return nil, err
+			}
+			result.Usage = append(result.Usage, models.WithdrawalLimitUsage{
+				Period: period.Name, Title: period.Title, Scope: models.WithdrawalLimitScopeGlobal, Limit: limit, Used: used,
+			})
+		}
+	}
+
+	if s.limits.CardCooldown > 0 && user.CardChangedAt != nil && !ignoreCooldown {
+		if until := user.CardChangedAt.Add(s.limits.CardCooldown); until.After(now) {
+			result.CooldownUntil = &until
+		}
+	}
+
+	return result, nil
+}
+
+// checkWithdrawalLimits проверяет, что заявку на итоговую сумму amount можно создать, если
+// дополнительно зарезервировать debit (сумма с комиссией) сверх уже зарезервированного.
+func checkWithdrawalLimits(l *models.WithdrawalLimits, amount, debit money.Amount) error {
+	if l.CooldownUntil != nil {
+		return fmt.Errorf("%w: после смены номера карты вывод станет доступен %s",
+			ErrWithdrawalLimit, l.CooldownUntil.Local().Format("02.01.2006 15:04"))
+	}
+	if l.Min > 0 && amount < l.Min {
+		return fmt.Errorf("%w: минимальная сумма вывода — %s %s", ErrWithdrawalLimit, l.Min, l.Currency)
+	}
+	if l.Max > 0 && amount > l.Max {
+		return fmt.Errorf("%w: максимальная сумма одной заявки — %s %s", ErrWithdrawalLimit, l.Max, l.Currency)
+	}
+	if debit <= 0 {
+		return nil
+	}
+
+	for _, usage := range l.Usage {
+		if usage.Used+debit <= usage.Limit {
+			continue
+		}
+		if usage.Scope == models.WithdrawalLimitScopeGlobal {
+			return fmt.Errorf("%w: исчерпан общий %s лимит выводов сервиса, попробуйте позже", ErrWithdrawalLimit, usage.Title)
+		}
+		return fmt.Errorf("%w: %s лимит — %s %s с учётом комиссии, из них уже использовано %s, доступно ещё %s %s",
+			ErrWithdrawalLimit, usage.Title, usage.Limit, l.Currency, usage.Used, max(usage.Limit-usage.Used, 0), l.Currency)
+	}
+	return nil
+}
+
+// SetWithdrawalLimitOverride назначает пользователю собственные лимиты на вывод по решению администратора adminID.
+// Суммы лимитов считаются в текущей валюте пользователя.
+func (s *Service) SetWithdrawalLimitOverride(ctx context.Context, adminID int64, override *models.WithdrawalLimitOverride) error {
+	user, err := s.repo.GetUser(ctx, override.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("пользователь с telegram_id %d не найден", override.UserID)
+	}
+	if override.MinAmount != nil && override.MaxAmount != nil && *override.MaxAmount != 0 && *override.MaxAmount < *override.MinAmount {
+		return errors.New("максимальная сумма меньше минимальной")
+	}
+
+	override.Currency = user.Currency
+	override.SetBy = adminID
+	if err := s.repo.SaveWithdrawalLimitOverride(ctx, override); err != nil {
+		return err
+	}
+	s.logger.Infof("Admin %d set withdrawal limits of user %d", adminID, override.UserID)
+	return nil
+}
+
+// ResetWithdrawalLimitOverride возвращает пользователю лимиты из настроек.
+func (s *Service) ResetWithdrawalLimitOverride(ctx context.Context, adminID, userID int64) error {
+	if err := s.repo.DeleteWithdrawalLimitOverride(ctx, userID); err != nil {
+		return err
+	}
+	s.logger.Infof("Admin %d reset withdrawal limits of user %d", adminID, userID)
+	return nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+// RecordPayout записывает фактический перевод администратора adminID по заявке: сумму, номер операции в банке и время.
+// Перевод на сумму заявки ждёт, пока пользователь подтвердит его получение. Перевод на другую сумму тоже
+// записывается, чтобы осталось, сколько на самом деле ушло, но заявка становится оспоренной и не может быть
+// завершена, пока перевод не записан заново на верную сумму. Перевод по оспоренной заявке можно записать заново.
+// Возвращает заявку с записанным переводом.
+func (s *Service) RecordPayout(ctx context.Context, adminID, withdrawalID int64, amount money.Amount, reference string, paidAt time.Time) (*models.Withdrawal, error) {
+	reference = strings.TrimSpace(reference)
+	if reference == "" {
+		return nil, errors.New("не указан номер операции в банке")
+	}
+
+	withdrawal, err := s.repo.GetWithdrawalByID(ctx, withdrawalID)
+	if err != nil {
+		return nil, err
+	}
+	if withdrawal == nil {
+		return nil, errors.New("withdrawal not found")
+	}
+
+	status := models.WithdrawalStatusPaid
+	actor := adminActor(adminID)
+	actor.comment = "операция " + reference
+	if amount != withdrawal.Amount {
+		status = models.WithdrawalStatusDisputed
+		actor.comment += fmt.Sprintf(": переведено %s вместо %s %s", amount, withdrawal.Amount, withdrawal.Currency)
+		s.logger.Warnf("Payout of withdrawal #%d is %s instead of %s %s", withdrawal.ID, amount, withdrawal.Amount, withdrawal.Currency)
+	}
+
+	err = s.moveWithdrawal(ctx, withdrawalID, status, actor, map[string]interface{}{
+		"payout_amount":    amount,
+		"payout_reference": reference,
+		"paid_at":          paidAt,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetWithdrawalByID(ctx, withdrawalID)
+}
+
+// ConfirmPayout завершает заявку пользователя userID после того, как он подтвердил получение перевода.
+func (s *Service) ConfirmPayout(ctx context.Context, userID, withdrawalID int64) error {
+	if err := s.checkWithdrawalOwner(ctx, userID, withdrawalID); err != nil {
+		return err
+	}
+	return s.completeWithdrawal(ctx, withdrawalID, userActor(userID))
+}
+
+// DisputePayout отмечает, что пользователь userID не получил записанный перевод или получил другую сумму.
+// Пока перевод не записан заново, заявку нельзя завершить.
+func (s *Service) DisputePayout(ctx context.Context, userID, withdrawalID int64) error {
+	if err := s.checkWithdrawalOwner(ctx, userID, withdrawalID); err != nil {
+		return err
+	}
+	withdrawal, err := s.repo.GetWithdrawalByID(ctx, withdrawalID)
+	if err != nil {
+		return err
+	}
+	// Оспорить можно только перевод, ждущий подтверждения
+	if withdrawal.Status != models.WithdrawalStatusPaid {
+		return fmt.Errorf("%w: заявка #%d в статусе %s", ErrWithdrawalStatus, withdrawal.ID, withdrawal.Status)
+	}
+	return s.moveWithdrawal(ctx, withdrawalID, models.WithdrawalStatusDisputed, userActor(userID), nil, nil)
+}
+
+func (s *Service) checkWithdrawalOwner(ctx context.Context, userID, withdrawalID int64) error {
+	withdrawal, err := s.repo.GetWithdrawalByID(ctx, withdrawalID)
+	if err != nil {
+		return err
+	}
+	if withdrawal == nil || withdrawal.UserID != userID {
+		return errors.New("withdrawal not found")
+	}
+	return nil
+}
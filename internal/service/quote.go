@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+// CreateQuote фиксирует для пользователя курс пополнения на сумму amount в валюте его баланса
+// и возвращает котировку с суммой в BTC, которую нужно отправить до её истечения.
+func (s *Service) CreateQuote(ctx context.Context, telegramID int64, amount money.Amount) (*models.Quote, error) {
+	if amount <= 0 {
+		return nil, errors.New("сумма должна быть положительной")
+	}
+
+	user, err := s.UpdateUserWallet(ctx, telegramID)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить адрес для пополнения: %w", err)
+	}
+
+	rate, err := s.currentRate(ctx, user.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	price, err := s.buyPrice(ctx, user, rate.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Сумма к оплате округляется вверх до сатоши, чтобы покрыть запрошенную сумму
+	amountSats := money.ToBTC(amount, price.EffectiveRate)
+	if amountSats < s.config.MinDepositSats {
+		return nil, fmt.Errorf("сумма меньше минимальной суммы пополнения (%.8f BTC)", s.config.MinDepositSats.ToBTC())
+	}
+
+	now := time.Now()
+	quote := &models.Quote{
+		UserID:     telegramID,
+		Address:    user.SystemWallet.Address,
+		AmountFiat: amount,
+		Currency:   user.Currency,
+		AmountSats: amountSats,
+		MarketRate: price.MarketRate,
+		Rate:       price.EffectiveRate,
+		RateSource: rate.Source,
+		Spread:     price.Spread,
+		Status:     models.QuoteStatusActive,
+		ExpiresAt:  now.Add(s.config.QuoteTTL),
+		CreatedAt:  now,
+	}
+
+	if err := s.repo.CreateQuote(ctx, quote); err != nil {
+		return nil, fmt.Errorf("не удалось сохранить котировку: %w", err)
+	}
+
+	return quote, nil
+}
+
+// quoteMatches проверяет, что транзакция — оплата по котировке: замечена в сети,
+// пока котировка действовала, и сумма отличается не больше допустимого.
+func (s *Service) quoteMatches(quote *models.Quote, user *models.User, tx *models.Transaction) bool {
+	if tx.Address != quote.Address || quote.Currency != user.Currency {
+		return false
+	}
+	if tx.CreatedAt.Before(quote.CreatedAt) || tx.CreatedAt.After(quote.ExpiresAt) {
+		return false
+	}
+
+	deviation := math.Abs(float64(tx.AmountSats-quote.AmountSats)) / float64(quote.AmountSats)
+	return deviation <= s.config.QuoteTolerancePercent/100
+}
+
+// PaymentURI возвращает BIP21-ссылку для оплаты котировки.
+func PaymentURI(quote *models.Quote) string {
+	amount := strconv.FormatFloat(quote.AmountSats.ToBTC(), 'f', 8, 64)
+	amount = strings.TrimRight(strings.TrimRight(amount, "0"), ".")
+	return fmt.Sprintf("bitcoin:%s?amount=%s", quote.Address, amount)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/rates"
+)
+
+// ErrRateUnavailable возвращается, когда курс не удалось получить ни из источников,
+// ни из достаточно свежей сохранённой записи. Зачисление в этом случае откладывается.
+var ErrRateUnavailable = errors.New("курс BTC временно недоступен")
+
+// currentRate возвращает актуальный курс BTC к валюте и сохраняет каждый успешно полученный.
+// Если источники недоступны, используется последний сохранённый курс не старше RateMaxAge.
+func (s *Service) currentRate(ctx context.Context, currency string) (rates.Rate, error) {
+	rate, err := s.rates.BTCRate(ctx, currency)
+	if err == nil {
+		if saveErr := s.saveRate(ctx, rate); saveErr != nil {
+			s.logger.Errorf("Failed to store exchange rate: %v", saveErr)
+		}
+		return rate, nil
+	}
+
+	s.logger.Warnf("Failed to get BTC/%s rate: %v", currency, err)
+
+	last, dbErr := s.repo.GetLatestExchangeRate(ctx, currency)
+	if dbErr != nil {
+		s.logger.Errorf("Failed to load last known exchange rate: %v", dbErr)
+	}
+	if last != nil && time.Since(last.FetchedAt) <= s.config.RateMaxAge {
+		s.logger.Warnf("Using last known BTC/%s rate %.2f from %s", currency, last.Value, last.FetchedAt.Format(time.RFC3339))
+		return rates.Rate{
+			Currency: last.Currency,
+			Value:    last.Value,
+			Source:   last.Source,
+			Time:     last.FetchedAt,
+		}, nil
+	}
+
+	return rates.Rate{}, fmt.Errorf("%w: %v", ErrRateUnavailable, err)
+}
+
+func (s *Service) saveRate(ctx context.Context, rate rates.Rate) error {
+	return s.repo.SaveExchangeRate(ctx, &models.ExchangeRate{
+		Currency:  rate.Currency,
+		Value:     rate.Value,
+		Source:    rate.Source,
+		FetchedAt: rate.Time,
+	})
+}
+
+// SnapshotRate запрашивает у источников курсы всех поддерживаемых валют и сохраняет их в историю.
+func (s *Service) SnapshotRate(ctx context.Context) error {
+	var errs []error
+	for _, currency := range s.config.SupportedCurrencies {
+		rate, err := s.rates.BTCRate(ctx, currency)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get BTC/%s rate: %w", currency, err))
+			continue
+		}
+
+		if err := s.saveRate(ctx, rate); err != nil {
+			errs = append(errs, fmt.Errorf("failed to store BTC/%s rate: %w", currency, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetRateSummary возвращает текущий курс и курс суточной давности для сравнения.
+func (s *Service) GetRateSummary(ctx context.Context, currency string) (*models.RateSummary, error) {
+	rate, err := s.currentRate(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	dayAgo, err := s.repo.GetExchangeRateAt(ctx, currency, rate.Time.Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RateSummary{
+		Current: models.ExchangeRate{
+			Currency:  rate.Currency,
+			Value:     rate.Value,
+			Source:    rate.Source,
+			FetchedAt: rate.Time,
+		},
+		DayAgo: dayAgo,
+	}, nil
+}
+
+func (s *Service) GetRateHistory(ctx context.Context, currency string, from, to time.Time) ([]models.ExchangeRate, error) {
+	return s.repo.GetExchangeRates(ctx, currency, from, to)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+)
+
+// Reconcile сверяет подтверждённые выходы на адресах пользователей с записями о пополнениях,
+// зачисленные пополнения — с журналом, а балансы — с остатками по журналу.
+// Ничего не исправляет, только возвращает найденные расхождения.
+func (s *Service) Reconcile(ctx context.Context) (*models.ReconcileReport, error) {
+	report := &models.ReconcileReport{StartedAt: time.Now()}
+
+	userIDs, err := s.repo.GetAllUserIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tipHeight, err := s.chain.TipHeight(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить высоту блокчейна: %w", err)
+	}
+
+	for _, userID := range userIDs {
+		user, err := s.repo.GetUser(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось получить пользователя %d: %w", userID, err)
+		}
+		if user == nil {
+			continue
+		}
+		report.Users++
+
+		if err := s.reconcileBooks(ctx, user, report); err != nil {
+			return nil, err
+		}
+		if user.SystemWallet != nil && user.SystemWallet.Address != "" {
+			s.reconcileChain(ctx, user, tipHeight, report)
+		}
+	}
+
+	s.logger.Infof("Reconciliation finished: %d users, %d issues", report.Users, len(report.Issues))
+	return report, nil
+}
+
+// reconcileBooks сверяет зачисленные пополнения с журналом и баланс с остатком по журналу.
+func (s *Service) reconcileBooks(ctx context.Context, user *models.User, report *models.ReconcileReport) error {
+	addIssue := func(kind, format string, args ...interface{}) {
+		report.Issues = append(report.Issues, models.ReconcileIssue{UserID: user.TelegramID, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if user.Balance < 0 {
+		addIssue(models.ReconcileNegativeBalance, "баланс %s %s", user.Balance, user.Currency)
+	}
+
+	drift, err := s.repo.RebuildUserBalance(ctx, user.TelegramID, false)
+	if err != nil {
+		return fmt.Errorf("не удалось сверить баланс пользователя %d: %w", user.TelegramID, err)
+	}
+	if drift != nil {
+		addIssue(models.ReconcileBalanceDrift, "баланс %s, по журналу %s %s", drift.Stored, drift.Ledger, drift.Currency)
+	}
+
+	keys, err := s.repo.GetLedgerKeys(ctx, user.TelegramID, models.LedgerEntryDeposit)
+	if err != nil {
+		return err
+	}
+	for _, tx := range user.Transactions {
+		// Пополнения, зачисленные до учёта по выходам, отражены в начальном остатке
+		if tx.Status != models.TransactionStatusCredited || tx.Vout == models.TransactionLegacyVout {
+			continue
+		}
+		if !keys["deposit:"+tx.Outpoint()] {
+			addIssue(models.ReconcileUnpostedCredit, "%s на %s %s", tx.Outpoint(), tx.AmountFiat, tx.Currency)
+		}
+	}
+
+	return nil
+}
+
+// reconcileChain сверяет выходы на адрес пользователя с записями о пополнениях.
+func (s *Service) reconcileChain(ctx context.Context, user *models.User, tipHeight int64, report *models.ReconcileReport) {
+	addIssue := func(kind, format string, args ...interface{}) {
+		report.Issues = append(report.Issues, models.ReconcileIssue{UserID: user.TelegramID, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	outputs, err := s.chain.AddressHistory(ctx, user.SystemWallet.Address, 0)
+	if err != nil {
+		s.logger.Errorf("Reconciliation: failed to get history of %s: %v", user.SystemWallet.Address, err)
+		addIssue(models.ReconcileChainError, "адрес %s: %v", user.SystemWallet.Address, err)
+		return
+	}
+
+	rows := make(map[string]*models.Transaction)
+	legacy := make(map[string]*models.Transaction)
+	for i := range user.Transactions {
+		tx := &user.Transactions[i]
+		if tx.Vout == models.TransactionLegacyVout {
+			legacy[tx.TxID] = tx
+		} else {
+			rows[tx.Outpoint()] = tx
+		}
+		if tx.Confirmed && isFinalTransactionStatus(tx.Status) {
+			report.StoredSats += tx.AmountSats
+		}
+	}
+
+	onChain := make(map[string]bool)
+	txIDs := make(map[string]bool)
+	for _, output := range outputs {
+		outpoint := fmt.Sprintf("%s:%d", output.TxID, output.Vout)
+		onChain[outpoint] = true
+		txIDs[output.TxID] = true
+
+		var confirmations int64
+		if output.Confirmed {
+			confirmations = tipHeight - output.BlockHeight + 1
+		}
+		if confirmations < s.config.MinConfirmations {
+			continue
+		}
+		report.ChainSats += output.Value
+
+		tx, ok := rows[outpoint]
+		if !ok {
+			tx, ok = legacy[output.TxID]
+		}
+		switch {
+		case !ok:
+			addIssue(models.ReconcileMissingDeposit, "%s на %.8f BTC, блок %d", outpoint, output.Value.ToBTC(), output.BlockHeight)
+		case !isFinalTransactionStatus(tx.Status):
+			addIssue(models.ReconcileUncreditedDeposit, "%s на %.8f BTC, статус %s", outpoint, output.Value.ToBTC(), tx.Status)
+		}
+	}
+
+	for _, tx := range user.Transactions {
+		known := onChain[tx.Outpoint()]
+		if tx.Vout == models.TransactionLegacyVout {
+			known = txIDs[tx.TxID]
+		}
+		if !known {
+			addIssue(models.ReconcileUnknownRow, "%s на %.8f BTC, статус %s", tx.Outpoint(), tx.AmountSats.ToBTC(), tx.Status)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+)
+
+// Rescan заново проходит всю историю адреса пользователя начиная с блока fromHeight
+// и зачисляет пропущенные пополнения. target — telegram ID пользователя или адрес.
+// Если пересканирование прервалось на полпути, вместе с ошибкой возвращается отчёт о том, что успели найти и сверить.
+func (s *Service) Rescan(ctx context.Context, target string, fromHeight int64, notifyCallback models.NotifyCallback) (*models.RescanReport, error) {
+	user, err := s.findRescanTarget(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.RescanReport{
+		UserID:     user.TelegramID,
+		Address:    user.SystemWallet.Address,
+		FromHeight: fromHeight,
+	}
+
+	_, err = s.checks.exclusive(user.TelegramID, func() (money.Amount, error) {
+		return s.rescanUser(ctx, user, report, notifyCallback)
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+func (s *Service) findRescanTarget(ctx context.Context, target string) (*models.User, error) {
+	var user *models.User
+	var err error
+	if telegramID, parseErr := strconv.ParseInt(target, 10, 64); parseErr == nil {
+		user, err = s.repo.GetUser(ctx, telegramID)
+	} else {
+		user, err = s.repo.GetUserByAddress(ctx, target)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("не удалось найти пользователя: %w", err)
+	}
+
+	if user == nil {
+		return nil, errors.New("пользователь не найден")
+	}
+	if user.SystemWallet == nil || user.SystemWallet.Address == "" {
+		return nil, errors.New("у пользователя нет адреса для пополнения")
+	}
+	return user, nil
+}
+
+func (s *Service) rescanUser(ctx context.Context, user *models.User, report *models.RescanReport, notifyCallback models.NotifyCallback) (money.Amount, error) {
+	s.logger.Infof("SERVICE: Rescanning address %s of user %d from height %d", report.Address, user.TelegramID, report.FromHeight)
+
+	outputs, err := s.chain.AddressHistory(ctx, report.Address, report.FromHeight)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось получить историю адреса: %w", err)
+	}
+
+	for _, output := range outputs {
+		report.Deposits = append(report.Deposits, models.RescanDeposit{
+			TxID:        output.TxID,
+			Vout:        int64(output.Vout),
+			AmountSats:  output.Value,
+			BlockHeight: output.BlockHeight,
+		})
+	}
+
+	for i := range report.Deposits {
+		existing, err := s.repo.GetTransaction(ctx, report.Deposits[i].TxID, report.Deposits[i].Vout)
+		if err != nil {
+			return 0, fmt.Errorf("не удалось проверить транзакцию: %w", err)
+		}
+		if existing != nil {
+			report.Deposits[i].PreviousStatus = existing.Status
+		}
+	}
+
+	readyTransactions, err := s.syncOutputs(ctx, user, outputs)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось сохранить транзакции: %w", err)
+	}
+
+	report.Currency = user.Currency
+	report.Credited, err = s.creditTransactions(ctx, user, readyTransactions, notifyCallback)
+
+	// Статусы обновляем и при ошибке зачисления: найденные выходы уже сохранены
+	for i := range report.Deposits {
+		tx, statusErr := s.repo.GetTransaction(ctx, report.Deposits[i].TxID, report.Deposits[i].Vout)
+		if statusErr != nil {
+			return 0, errors.Join(err, fmt.Errorf("не удалось проверить транзакцию: %w", statusErr))
+		}
+		if tx != nil {
+			report.Deposits[i].Status = tx.Status
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return report.Credited, nil
+}
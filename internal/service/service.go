@@ -4,9 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Fi44er/btc_bot/config"
+	"github.com/Fi44er/btc_bot/internal/chain"
+	"github.com/Fi44er/btc_bot/internal/fees"
+	"github.com/Fi44er/btc_bot/internal/limits"
 	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/internal/pricing"
+	"github.com/Fi44er/btc_bot/internal/rates"
 	"github.com/Fi44er/btc_bot/utils"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
@@ -16,22 +23,35 @@ import (
 
 type Service struct {
 	repo        Repository
+	chain       chain.Backend
+	rates       rates.Provider
+	pricing     *pricing.Pricing
+	fees        *fees.Fees
+	limits      *limits.Limits
 	masterKey   *hdkeychain.ExtendedKey
 	netParams   *chaincfg.Params
 	addressIdx  uint32
 	adminChatID int64
 	logger      *utils.Logger
 	config      *config.Config
+	checks      *checkGroup
 }
 
 type Repository interface {
 	GetUser(ctx context.Context, telegramID int64) (*models.User, error)
 	CreateUser(ctx context.Context, user *models.User) error
-	UpdateUser(ctx context.Context, user *models.User, tx *gorm.DB) error
+	UpdateUserCard(ctx context.Context, telegramID int64, cardNumber string, changedAt *time.Time, tx *gorm.DB) error
+	SetUserWallet(ctx context.Context, telegramID, walletID int64, tx *gorm.DB) error
+	SetUserVIP(ctx context.Context, telegramID int64, vip bool) error
+	UpdateUserCurrency(ctx context.Context, telegramID int64, currency string, tx *gorm.DB) error
 	GetUserByAddress(ctx context.Context, address string) (*models.User, error)
 
-	GetTransaction(ctx context.Context, txID string) (*models.Transaction, error)
-	CreateOrUpdateTransaction(ctx context.Context, tx *models.Transaction) error
+	GetTransaction(ctx context.Context, txID string, vout int64) (*models.Transaction, error)
+	GetTransactionsByUser(ctx context.Context, userID int64, limit int) ([]models.Transaction, error)
+	GetTransactionsByStatus(ctx context.Context, userID int64, status string) ([]models.Transaction, error)
+	GetAllTransactionsByStatuses(ctx context.Context, statuses ...string) ([]models.Transaction, error)
+	SumCreditedFiat(ctx context.Context, userID int64, currency string) (money.Amount, error)
+	CreateOrUpdateTransaction(ctx context.Context, tx *models.Transaction, dbTx *gorm.DB) error
 
 	CreateWallet(ctx context.Context, wallet *models.SystemWallet, tx *gorm.DB) error
 	GetWalletByID(ctx context.Context, id int64) (*models.SystemWallet, error)
@@ -42,23 +62,63 @@ type Repository interface {
 	WithTransaction(tx *gorm.DB) *gorm.DB
 
 	GetAllWithdrawals(ctx context.Context) ([]models.Withdrawal, error)
-	CreateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error
-	SumPendingWithdrawals(ctx context.Context, userID int64) (float64, error)
+	CreateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal, tx *gorm.DB) error
+	SumUserReserved(ctx context.Context, userID int64, currency string) (money.Amount, error)
 	GetPendingWithdrawalByUser(ctx context.Context, userID int64) (*models.Withdrawal, error)
-	UpdateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) error
+	UpdateWithdrawal(ctx context.Context, withdrawal *models.Withdrawal, tx *gorm.DB) error
+	SumWithdrawalHold(ctx context.Context, withdrawalID uint, tx *gorm.DB) (money.Amount, error)
 
 	GetPendingWithdrawals(ctx context.Context) ([]*models.Withdrawal, error)
 	GetWithdrawalByID(ctx context.Context, id int64) (*models.Withdrawal, error)
-	UpdateWithdrawalStatus(ctx context.Context, id int64, status string) error
+	LockWithdrawal(ctx context.Context, id int64, tx *gorm.DB) (*models.Withdrawal, error)
+	CreateWithdrawalTransition(ctx context.Context, transition *models.WithdrawalTransition, tx *gorm.DB) error
+	GetWithdrawalTransitions(ctx context.Context, withdrawalID uint) ([]models.WithdrawalTransition, error)
 
 	GetPendingWithdrawalByUserID(ctx context.Context, userID int64) (*models.Withdrawal, error)
 	DeleteWithdrawal(ctx context.Context, id int64) error
-	UpdateUserBalance(ctx context.Context, userID int64, newBalance float64) error
+
+	GetWithdrawalsByUser(ctx context.Context, userID int64, limit int) ([]models.Withdrawal, error)
+	SumWithdrawalHolds(ctx context.Context, userID int64, currency string, since time.Time, tx *gorm.DB) (money.Amount, error)
+	GetWithdrawalLimitOverride(ctx context.Context, userID int64) (*models.WithdrawalLimitOverride, error)
+	SaveWithdrawalLimitOverride(ctx context.Context, override *models.WithdrawalLimitOverride) error
+	DeleteWithdrawalLimitOverride(ctx context.Context, userID int64) error
+
+	CreateDispute(ctx context.Context, dispute *models.Dispute, tx *gorm.DB) error
+	UpdateDispute(ctx context.Context, dispute *models.Dispute, tx *gorm.DB) error
+	GetDisputeByID(ctx context.Context, id int64) (*models.Dispute, error)
+	LockDispute(ctx context.Context, id int64, tx *gorm.DB) (*models.Dispute, error)
+	GetOpenDisputes(ctx context.Context) ([]models.Dispute, error)
+	GetDisputesByUser(ctx context.Context, userID int64, limit int) ([]models.Dispute, error)
+	CountOpenUserDisputes(ctx context.Context, userID int64, tx *gorm.DB) (int64, error)
+	GetOpenWithdrawalDispute(ctx context.Context, withdrawalID uint, tx *gorm.DB) (*models.Dispute, error)
+	GetOpenDepositDisputes(ctx context.Context, userID int64, tx *gorm.DB) (map[string]bool, error)
+	CreateDisputeMessage(ctx context.Context, message *models.DisputeMessage) error
+	GetDisputeMessages(ctx context.Context, disputeID uint) ([]models.DisputeMessage, error)
 
 	GetAllUsersWithAddresses(ctx context.Context) ([]*models.User, error)
+	GetAllUserIDs(ctx context.Context) ([]int64, error)
+
+	PostLedgerEntry(ctx context.Context, entry *models.LedgerEntry, tx *gorm.DB) error
+	RebuildUserBalance(ctx context.Context, userID int64, fix bool) (*models.BalanceDrift, error)
+	GetLedgerKeys(ctx context.Context, userID int64, kind string) (map[string]bool, error)
+	LockUser(ctx context.Context, telegramID int64, tx *gorm.DB) (*models.User, error)
+	CreateBalanceAdjustment(ctx context.Context, adjustment *models.BalanceAdjustment, tx *gorm.DB) error
+	GetLedgerEntriesByUser(ctx context.Context, userID int64, exclude ...string) ([]models.LedgerEntry, error)
+	SumUserLedger(ctx context.Context, userID int64, currency string, before time.Time) (money.Amount, error)
+	GetUserLedgerEntriesBetween(ctx context.Context, userID int64, currency string, from, to time.Time) ([]models.LedgerEntry, error)
+
+	CreateQuote(ctx context.Context, quote *models.Quote) error
+	GetActiveQuote(ctx context.Context, userID int64) (*models.Quote, error)
+	MarkQuoteUsed(ctx context.Context, id uint, txID string, tx *gorm.DB) error
+	CancelActiveQuotes(ctx context.Context, userID int64) error
+
+	SaveExchangeRate(ctx context.Context, rate *models.ExchangeRate) error
+	GetLatestExchangeRate(ctx context.Context, currency string) (*models.ExchangeRate, error)
+	GetExchangeRateAt(ctx context.Context, currency string, at time.Time) (*models.ExchangeRate, error)
+	GetExchangeRates(ctx context.Context, currency string, from, to time.Time) ([]models.ExchangeRate, error)
 }
 
-func NewUserService(repo Repository, masterKeySeed string, adminChatID int64, coconfig *config.Config, logger *utils.Logger) (*Service, error) {
+func NewUserService(repo Repository, chainBackend chain.Backend, rateProvider rates.Provider, prices *pricing.Pricing, withdrawalFees *fees.Fees, withdrawalLimits *limits.Limits, masterKeySeed string, adminChatID int64, coconfig *config.Config, logger *utils.Logger) (*Service, error) {
 	masterKey, err := hdkeychain.NewKeyFromString(masterKeySeed)
 	if err != nil {
 		return nil, err
@@ -68,9 +128,15 @@ func NewUserService(repo Repository, masterKeySeed string, adminChatID int64, co
 		logger:      logger,
 		config:      coconfig,
 		repo:        repo,
+		chain:       chainBackend,
+		rates:       rateProvider,
+		pricing:     prices,
+		fees:        withdrawalFees,
+		limits:      withdrawalLimits,
 		masterKey:   masterKey,
 		netParams:   &chaincfg.MainNetParams,
 		adminChatID: adminChatID,
+		checks:      newCheckGroup(),
 	}, nil
 }
 
@@ -81,6 +147,7 @@ func (s *Service) GetAdminChatID() int64 {
 func (s *Service) CreateUser(ctx context.Context, userID int64) error {
 	user := &models.User{
 		TelegramID: userID,
+		Currency:   s.config.DefaultCurrency,
 	}
 	return s.repo.CreateUser(ctx, user)
 }
@@ -110,29 +177,37 @@ func (s *Service) UpdateUserWallet(ctx context.Context, telegramID int64) (*mode
 		Address:    address,
 		PrivateKey: privateAddrKey,
 	}
-	user.SystemWallet = wallet
 
 	tx, err := s.repo.BeginTransaction(ctx)
 	if err != nil {
 		return nil, err
 	}
-
+	committed := false
 	defer func() {
-		if r := recover(); r != nil {
-			s.logger.Errorf("Panic occurred: %v", r)
+		if !committed {
 			s.repo.Rollback(tx)
 		}
 	}()
 
+	// Адрес мог появиться, пока генерировался ключ: тогда новый не создаём
+	locked, err := s.repo.LockUser(ctx, telegramID, s.repo.WithTransaction(tx))
+	if err != nil {
+		return nil, err
+	}
+	if locked == nil {
+		return nil, errors.New("user not found")
+	}
+	if locked.SystemWalletID != nil {
+		return s.repo.GetUser(ctx, telegramID)
+	}
+
 	if err := s.repo.CreateWallet(ctx, wallet, tx); err != nil {
 		s.logger.Errorf("Failed to create wallet: %v", err)
-		s.repo.Rollback(tx)
 		return nil, err
 	}
 
-	if err := s.repo.UpdateUser(ctx, user, tx); err != nil {
+	if err := s.repo.SetUserWallet(ctx, telegramID, wallet.ID, tx); err != nil {
 		s.logger.Errorf("Failed to update user: %v", err)
-		s.repo.Rollback(tx)
 		return nil, err
 	}
 
@@ -140,30 +215,131 @@ func (s *Service) UpdateUserWallet(ctx context.Context, telegramID int64) (*mode
 		s.logger.Errorf("Failed to commit transaction: %v", err)
 		return nil, err
 	}
+	committed = true
 
+	user.SystemWalletID = &wallet.ID
+	user.SystemWallet = wallet
 	return user, nil
 }
 
+// UpdateCardNumber сохраняет номер карты пользователя. Меняются только карта и время её смены,
+// поэтому параллельная смена валюты или VIP-статуса не затирается.
 func (s *Service) UpdateCardNumber(ctx context.Context, telegramID int64, cardNumber string) error {
-	user, err := s.repo.GetUser(ctx, telegramID)
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.repo.Rollback(tx)
+		}
+	}()
+
+	user, err := s.repo.LockUser(ctx, telegramID, s.repo.WithTransaction(tx))
 	if err != nil {
 		return err
 	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	// Смена уже указанной карты запускает паузу перед выводом, первое указание — нет
+	var changedAt *time.Time
+	if user.CardNumber != "" && user.CardNumber != cardNumber {
+		now := time.Now()
+		changedAt = &now
+	}
+	if err := s.repo.UpdateUserCard(ctx, telegramID, cardNumber, changedAt, s.repo.WithTransaction(tx)); err != nil {
+		return err
+	}
+
+	if err := s.repo.Commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+func (s *Service) SetUserVIP(ctx context.Context, telegramID int64, vip bool) error {
+	return s.repo.SetUserVIP(ctx, telegramID, vip)
+}
+
+// SetUserCurrency меняет валюту баланса. Менять валюту можно только при нулевом балансе,
+// без незавершённых выводов и открытых споров: проводки по спору идут в валюте баланса на момент его открытия.
+// Действующие котировки при этом отменяются.
+func (s *Service) SetUserCurrency(ctx context.Context, telegramID int64, currency string) error {
+	if !s.config.IsSupportedCurrency(currency) {
+		return fmt.Errorf("валюта %s не поддерживается", currency)
+	}
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.repo.Rollback(tx)
+		}
+	}()
 
+	// Блокировка пользователя упорядочивает смену валюты с открытием спора
+	user, err := s.repo.LockUser(ctx, telegramID, s.repo.WithTransaction(tx))
+	if err != nil {
+		return err
+	}
 	if user == nil {
 		return errors.New("user not found")
 	}
+	if user.Currency == currency {
+		return nil
+	}
+
+	if user.Balance != 0 {
+		return errors.New("сменить валюту можно только при нулевом балансе")
+	}
+
+	pending, err := s.repo.GetPendingWithdrawalByUserID(ctx, telegramID)
+	if err != nil {
+		return err
+	}
+	if pending != nil {
+		return errors.New("сменить валюту нельзя, пока есть незавершённая заявка на вывод")
+	}
+
+	disputes, err := s.repo.CountOpenUserDisputes(ctx, telegramID, s.repo.WithTransaction(tx))
+	if err != nil {
+		return err
+	}
+	if disputes > 0 {
+		return errors.New("сменить валюту нельзя, пока открыт спор")
+	}
+
+	if err := s.repo.CancelActiveQuotes(ctx, telegramID); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateUserCurrency(ctx, telegramID, currency, s.repo.WithTransaction(tx)); err != nil {
+		return err
+	}
+
+	if err := s.repo.Commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+	return nil
+}
 
-	user.CardNumber = cardNumber
-	return s.repo.UpdateUser(ctx, user, nil)
+func (s *Service) GetSupportedCurrencies() []string {
+	return s.config.SupportedCurrencies
 }
 
 func (s *Service) GetUserByAddress(ctx context.Context, address string) (*models.User, error) {
 	return s.repo.GetUserByAddress(ctx, address)
 }
 
-func (s *Service) IsTransactionProcessed(ctx context.Context, txID string) (*models.Transaction, error) {
-	return s.repo.GetTransaction(ctx, txID)
+func (s *Service) IsTransactionProcessed(ctx context.Context, txID string, vout int64) (*models.Transaction, error) {
+	return s.repo.GetTransaction(ctx, txID, vout)
 }
 
 func (s *Service) GetUser(ctx context.Context, telegramID int64) (*models.User, error) {
@@ -171,7 +347,7 @@ func (s *Service) GetUser(ctx context.Context, telegramID int64) (*models.User,
 }
 
 func (s *Service) CreateOrUpdateTransaction(ctx context.Context, tx *models.Transaction) error {
-	return s.repo.CreateOrUpdateTransaction(ctx, tx)
+	return s.repo.CreateOrUpdateTransaction(ctx, tx, nil)
 }
 
 func (s *Service) generateNewAddressAndKey() (string, string, error) {
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fi44er/btc_bot/internal/models"
+)
+
+// GetStatement собирает выписку пользователя за период [from, to) по журналу:
+// остаток на начало, каждое изменение баланса с остатком после него и остаток на конец.
+// Остатки включают резерв по заявкам на вывод, поэтому сам резерв в выписку не попадает,
+// а вывод и комиссия отражаются при списании. Выписка строится в текущей валюте пользователя.
+func (s *Service) GetStatement(ctx context.Context, userID int64, from, to time.Time) (*models.Statement, error) {
+	if !from.Before(to) {
+		return nil, errors.New("statement period is empty")
+	}
+
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	opening, err := s.repo.SumUserLedger(ctx, userID, user.Currency, from)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.repo.GetUserLedgerEntriesBetween(ctx, userID, user.Currency, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &models.Statement{
+		UserID:   userID,
+		Currency: user.Currency,
+		From:     from,
+		To:       to,
+		Opening:  opening,
+		Closing:  opening,
+	}
+	for _, entry := range entries {
+		line := models.StatementLine{
+			Time:        entry.CreatedAt,
+			Kind:        entry.Kind,
+			Description: entry.Description,
+		}
+		switch {
+		case entry.TransactionID != nil:
+			line.Reference = *entry.TransactionID
+		case entry.WithdrawalID != nil:
+			line.Reference = fmt.Sprintf("#%d", *entry.WithdrawalID)
+		}
+		for _, posting := range entry.Postings {
+			line.Amount += posting.Amount
+		}
+		if line.Amount == 0 {
+			continue
+		}
+
+		statement.Closing += line.Amount
+		line.Balance = statement.Closing
+		statement.Lines = append(statement.Lines, line)
+	}
+
+	return statement, nil
+}
@@ -2,22 +2,26 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"strconv"
 	"time"
 
+	"github.com/Fi44er/btc_bot/internal/chain"
 	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"github.com/Fi44er/btc_bot/internal/pricing"
+	"github.com/btcsuite/btcd/btcutil"
 )
 
-var (
-	testnetAPIURL = "https://mempool.space/testnet4/api"
-	mainnetAPIURL = "https://mempool.space/api"
-)
+// HandleCheckTransactions проверяет адрес пользователя и зачисляет подтверждённые пополнения.
+// Одновременные проверки одного пользователя (по расписанию и по кнопке) объединяются в одну.
+func (s *Service) HandleCheckTransactions(ctx context.Context, userID int64, notifyCallback models.NotifyCallback) (money.Amount, error) {
+	return s.checks.do(userID, func() (money.Amount, error) {
+		return s.checkAndCreditTransactions(ctx, userID, notifyCallback)
+	})
+}
 
-func (s *Service) HandleCheckTransactions(ctx context.Context, userID int64, notifyCallback models.NotifyCallback) (float64, error) {
+func (s *Service) checkAndCreditTransactions(ctx context.Context, userID int64, notifyCallback models.NotifyCallback) (money.Amount, error) {
 	s.logger.Infof("SERVICE: Starting HandleCheckTransactions for user %d", userID)
 	user, err := s.GetUser(ctx, userID)
 	if err != nil || user == nil || user.SystemWallet == nil || user.SystemWallet.Address == "" {
@@ -25,177 +29,340 @@ func (s *Service) HandleCheckTransactions(ctx context.Context, userID int64, not
 		return 0, fmt.Errorf("у вас нет активного адреса для проверки")
 	}
 
-	newTransactions, err := s.checkUserTransactions(ctx, user, notifyCallback)
+	outputs, err := s.chain.AddressOutputs(ctx, user.SystemWallet.Address)
 	if err != nil {
 		s.logger.Errorf("Error checking transactions for user %d: %v", userID, err)
 		return 0, fmt.Errorf("произошла ошибка при проверке транзакций")
 	}
 
-	if len(newTransactions) == 0 {
-		return 0, nil
+	readyTransactions, err := s.syncOutputs(ctx, user, outputs)
+	if err != nil {
+		s.logger.Errorf("Error checking transactions for user %d: %v", userID, err)
+		return 0, fmt.Errorf("произошла ошибка при проверке транзакций")
 	}
 
-	totalBTC := 0.0
-	for _, tx := range newTransactions {
-		if tx.Confirmed {
-			totalBTC += tx.AmountBTC
-		}
+	return s.creditTransactions(ctx, user, readyTransactions, notifyCallback)
+}
+
+// creditTransactions зачисляет на баланс готовые к зачислению транзакции вместе с отложенными,
+// либо откладывает их, если вместе они не дотягивают до минимальной суммы.
+func (s *Service) creditTransactions(ctx context.Context, user *models.User, readyTransactions []models.Transaction, notifyCallback models.NotifyCallback) (money.Amount, error) {
+	userID := user.TelegramID
+
+	// Пополнения с открытым спором не зачисляются до его решения и остаются в прежнем статусе
+	disputed, err := s.repo.GetOpenDepositDisputes(ctx, userID, nil)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось проверить споры по пополнениям: %v", err)
 	}
+	readyTransactions = withoutDisputed(readyTransactions, disputed)
 
-	if totalBTC == 0 {
+	if len(readyTransactions) == 0 {
 		return 0, nil
 	}
 
-	rate, err := s.GetBTCRUBRate()
+	heldTransactions, err := s.repo.GetTransactionsByStatus(ctx, userID, models.TransactionStatusHeld)
 	if err != nil {
-		s.logger.Warnf("Failed to get BTC/RUB rate, using fallback: %v", err)
-		rate = 3900027.0 // Лучше вынести в конфиг
+		return 0, fmt.Errorf("не удалось получить отложенные пополнения: %v", err)
 	}
+	heldTransactions = withoutDisputed(heldTransactions, disputed)
 
-	totalRUB := totalBTC * rate
+	var total btcutil.Amount
+	for _, tx := range append(heldTransactions, readyTransactions...) {
+		total += tx.AmountSats
+	}
 
-	currentUser, err := s.GetUser(ctx, userID)
-	if err != nil {
-		return 0, fmt.Errorf("не удалось получить пользователя перед обновлением баланса: %v", err)
+	// Пока сумма накопленных поступлений меньше минимальной, откладываем их
+	if total < s.config.MinDepositSats {
+		s.holdTransactions(ctx, user, readyTransactions, notifyCallback)
+		return 0, nil
 	}
 
-	currentUser.Balance += totalRUB
+	readyTransactions = append(heldTransactions, readyTransactions...)
 
-	if err := s.repo.UpdateUser(ctx, currentUser, nil); err != nil {
-		return 0, fmt.Errorf("не удалось обновить баланс: %v", err)
+	readyTransactions, rateErr := s.priceTransactions(ctx, user, readyTransactions)
+	if len(readyTransactions) == 0 {
+		return 0, rateErr
 	}
 
-	s.logger.Infof("Successfully added %.2f RUB to user %d balance.", totalRUB, userID)
-	return totalRUB, nil
-}
+	now := time.Now()
+	var totalAmount money.Amount
+	var credited []models.Transaction
+	var creditErr error
+	for _, tx := range readyTransactions {
+		tx.Currency = user.Currency
+		tx.AmountFiat = money.FromBTC(tx.AmountSats, tx.Rate)
+		tx.CreditedAt = &now
+		tx.Status = models.TransactionStatusCredited
+
+		ok, err := s.creditDeposit(ctx, user, &tx)
+		if err != nil {
+			s.logger.Errorf("Failed to credit deposit %s of user %d: %v", tx.Outpoint(), userID, err)
+			creditErr = fmt.Errorf("не удалось обновить баланс: %v", err)
+			continue
+		}
+		if ok {
+			totalAmount += tx.AmountFiat
+			credited = append(credited, tx)
+		}
+	}
 
-func (s *Service) checkUserTransactions(ctx context.Context, user *models.User, notifyCallback models.NotifyCallback) ([]models.Transaction, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
-	url := fmt.Sprintf("%s/address/%s/txs", mainnetAPIURL, user.SystemWallet.Address)
+	if len(credited) == 0 {
+		return 0, errors.Join(rateErr, creditErr)
+	}
 
-	resp, err := client.Get(url)
+	currentUser, err := s.GetUser(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("API request failed: %v", err)
+		return totalAmount, fmt.Errorf("не удалось получить пользователя после обновления баланса: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	for i := range credited {
+		tx := &credited[i]
+		if notifyCallback != nil {
+			s.logger.Infof("SERVICE: Transaction %s credited to user %d. CALLING NOTIFY CALLBACK.", tx.Outpoint(), userID)
+			notifyCallback(currentUser, tx)
+		} else {
+			s.logger.Error("SERVICE: NOTIFY CALLBACK IS NIL! Cannot notify bot.")
+		}
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+	s.logger.Infof("Successfully added %s %s to user %d balance.", totalAmount, user.Currency, userID)
+	return totalAmount, errors.Join(rateErr, creditErr)
+}
+
+func withoutDisputed(txs []models.Transaction, disputed map[string]bool) []models.Transaction {
+	if len(disputed) == 0 {
+		return txs
+	}
+	var result []models.Transaction
+	for _, tx := range txs {
+		if !disputed[tx.Outpoint()] {
+			result = append(result, tx)
+		}
 	}
+	return result
+}
 
-	var apiResponse []struct {
-		TxID string `json:"txid"`
-		Vout []struct {
-			Address string `json:"scriptpubkey_address"`
-			Value   uint64 `json:"value"`
-		} `json:"vout"`
-		Status struct {
-			Confirmed bool `json:"confirmed"`
-		} `json:"status"`
+// creditDeposit в одной транзакции БД проводит зачисление по журналу, помечает пополнение
+// зачисленным и закрывает котировку. Ключ записи журнала уникален для выхода, поэтому
+// повторная проверка не зачислит его дважды; в этом случае возвращается false.
+func (s *Service) creditDeposit(ctx context.Context, user *models.User, tx *models.Transaction) (bool, error) {
+	dbTx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return false, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.repo.Rollback(dbTx)
+		}
+	}()
+
+	key := "deposit:" + tx.Outpoint()
+	entry := userEntry(models.LedgerEntryDeposit, user, tx.AmountFiat, models.LedgerAccountDeposits,
+		fmt.Sprintf("пополнение %.8f BTC по курсу %.2f", tx.AmountSats.ToBTC(), tx.Rate))
+	entry.TransactionID = &tx.TxID
+	entry.Key = &key
+
+	posted := true
+	err = s.postEntry(ctx, entry, s.repo.WithTransaction(dbTx))
+	if errors.Is(err, models.ErrAlreadyPosted) {
+		// Зачисление уже проведено, осталось только отметить пополнение
+		s.logger.Warnf("Deposit %s is already in the ledger, marking as credited", tx.Outpoint())
+		posted = false
+		tx = &models.Transaction{TxID: tx.TxID, Vout: tx.Vout, Status: models.TransactionStatusCredited}
+	} else if err != nil {
+		return false, err
 	}
 
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		s.logger.Errorf("Failed to decode JSON: %v\nRaw response: %s", err, string(body))
-		return nil, fmt.Errorf("invalid API response format")
+	if err := s.repo.CreateOrUpdateTransaction(ctx, tx, s.repo.WithTransaction(dbTx)); err != nil {
+		return false, fmt.Errorf("failed to mark deposit as credited: %w", err)
+	}
+	if posted && tx.QuoteID != nil {
+		if err := s.repo.MarkQuoteUsed(ctx, *tx.QuoteID, tx.TxID, s.repo.WithTransaction(dbTx)); err != nil {
+			return false, err
+		}
 	}
 
-	var processedNewTransactions []models.Transaction
+	if err := s.repo.Commit(dbTx); err != nil {
+		return false, fmt.Errorf("failed to commit credit: %w", err)
+	}
+	committed = true
 
-	for _, txData := range apiResponse {
-		for _, output := range txData.Vout {
-			if output.Address == user.SystemWallet.Address {
-				amountBTC := float64(output.Value) / 1e8
+	return posted, nil
+}
 
-				isNew, err := s.processTransaction(ctx, txData.TxID, user.TelegramID, user.SystemWallet.Address, amountBTC, txData.Status.Confirmed)
-				if err != nil {
-					s.logger.Errorf("Transaction processing failed: %v", err)
-					continue
-				}
+// priceTransactions назначает транзакциям курс зачисления. Оплата по действующей котировке
+// получает зафиксированный в ней курс, остальные — текущий курс с учётом спреда.
+// Если текущий курс недоступен, такие транзакции откладываются до следующей проверки
+// (они остаются подтверждёнными), а вместе с оценёнными возвращается ErrRateUnavailable.
+func (s *Service) priceTransactions(ctx context.Context, user *models.User, txs []models.Transaction) ([]models.Transaction, error) {
+	quote, err := s.repo.GetActiveQuote(ctx, user.TelegramID)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить котировку: %v", err)
+	}
 
-				if isNew && txData.Status.Confirmed {
-					newTxModel := models.Transaction{
-						TxID:      txData.TxID,
-						UserID:    user.TelegramID,
-						Address:   user.SystemWallet.Address,
-						AmountBTC: amountBTC,
-						Confirmed: true,
-					}
-
-					if notifyCallback != nil {
-						s.logger.Infof("SERVICE: New confirmed transaction %s found for user %d. CALLING NOTIFY CALLBACK.", newTxModel.TxID, user.TelegramID)
-						notifyCallback(user, &newTxModel)
-					} else {
-						s.logger.Error("SERVICE: NOTIFY CALLBACK IS NIL! Cannot notify bot.")
-					}
-
-					processedNewTransactions = append(processedNewTransactions, newTxModel)
-				}
-			}
+	var priced, unpriced []models.Transaction
+	for _, tx := range txs {
+		if quote != nil && s.quoteMatches(quote, user, &tx) {
+			tx.QuoteID = &quote.ID
+			tx.MarketRate = quote.MarketRate
+			tx.Rate = quote.Rate
+			tx.RateSource = quote.RateSource
+			tx.RateAt = &quote.CreatedAt
+			tx.Spread = quote.Spread
+			tx.PriceTier = fmt.Sprintf("котировка #%d", quote.ID)
+			priced = append(priced, tx)
+			quote = nil // одна котировка — одна оплата
+			continue
 		}
+		unpriced = append(unpriced, tx)
 	}
 
-	return processedNewTransactions, nil
-}
+	if len(unpriced) == 0 {
+		return priced, nil
+	}
 
-func (s *Service) processTransaction(ctx context.Context, txID string, userID int64, address string, amountBTC float64, confirmed bool) (bool, error) {
-	if amountBTC <= 0 {
-		return false, nil
+	// Без актуального курса не зачисляем: транзакции остаются подтверждёнными
+	// и будут зачислены при следующей проверке.
+	rate, err := s.currentRate(ctx, user.Currency)
+	if err != nil {
+		s.logger.Warnf("Deferring credit of %d transactions for user %d: %v", len(unpriced), user.TelegramID, err)
+		return priced, err
 	}
 
-	existingTx, err := s.repo.GetTransaction(ctx, txID)
+	price, err := s.buyPrice(ctx, user, rate.Value)
 	if err != nil {
-		return false, fmt.Errorf("failed to check existing transaction: %w", err)
+		return nil, err
 	}
-	if existingTx != nil {
-		return false, nil
+
+	for _, tx := range unpriced {
+		tx.MarketRate = price.MarketRate
+		tx.Rate = price.EffectiveRate
+		tx.RateSource = rate.Source
+		tx.RateAt = &rate.Time
+		tx.Spread = price.Spread
+		tx.PriceTier = price.Tier
+		priced = append(priced, tx)
 	}
 
-	tx := &models.Transaction{
-		TxID:      txID,
-		UserID:    userID,
-		Address:   address,
-		AmountBTC: amountBTC,
-		Confirmed: confirmed,
+	return priced, nil
+}
+
+func (s *Service) buyPrice(ctx context.Context, user *models.User, marketRate float64) (pricing.Price, error) {
+	volume, err := s.repo.SumCreditedFiat(ctx, user.TelegramID, user.Currency)
+	if err != nil {
+		return pricing.Price{}, fmt.Errorf("не удалось получить оборот пользователя: %v", err)
 	}
+	return s.pricing.BuyPrice(marketRate, volume.Major(), user.IsVIP), nil
+}
 
-	if err := s.repo.CreateOrUpdateTransaction(ctx, tx); err != nil {
-		return false, fmt.Errorf("failed to save transaction: %v", err)
+func (s *Service) holdTransactions(ctx context.Context, user *models.User, txs []models.Transaction, notifyCallback models.NotifyCallback) {
+	for i := range txs {
+		tx := &txs[i]
+		tx.Status = models.TransactionStatusHeld
+		if err := s.repo.CreateOrUpdateTransaction(ctx, tx, nil); err != nil {
+			s.logger.Errorf("Failed to hold transaction %s: %v", tx.Outpoint(), err)
+			continue
+		}
+
+		s.logger.Infof("Transaction %s of user %d is below minimum deposit, holding", tx.TxID, user.TelegramID)
+		if notifyCallback != nil {
+			notifyCallback(user, tx)
+		}
 	}
+}
+
+// GetHeldDeposits возвращает отложенные и проигнорированные пополнения всех пользователей.
+func (s *Service) GetHeldDeposits(ctx context.Context) ([]models.Transaction, error) {
+	return s.repo.GetAllTransactionsByStatuses(ctx, models.TransactionStatusHeld, models.TransactionStatusIgnored)
+}
 
-	return true, nil
+// GetUserDeposits возвращает последние пополнения пользователя вместе с их статусами.
+func (s *Service) GetUserDeposits(ctx context.Context, userID int64, limit int) ([]models.Transaction, error) {
+	return s.repo.GetTransactionsByUser(ctx, userID, limit)
 }
 
-func (s *Service) GetBTCRUBRate() (float64, error) {
-	resp, err := http.Get("https://api.binance.com/api/v3/ticker/price?symbol=BTCRUB")
+// syncOutputs сохраняет найденные на адресе выходы и возвращает те,
+// что набрали нужное число подтверждений, но ещё не зачислены.
+func (s *Service) syncOutputs(ctx context.Context, user *models.User, outputs []chain.Output) ([]models.Transaction, error) {
+	var tipHeight int64
+	var readyTransactions []models.Transaction
+
+	for _, output := range outputs {
+		var confirmations int64
+		if output.Confirmed {
+			if tipHeight == 0 {
+				var err error
+				if tipHeight, err = s.chain.TipHeight(ctx); err != nil {
+					return nil, err
+				}
+			}
+			confirmations = tipHeight - output.BlockHeight + 1
+		}
+
+		tx, err := s.processTransaction(ctx, user.TelegramID, output, confirmations)
+		if err != nil {
+			s.logger.Errorf("Transaction processing failed: %v", err)
+			continue
+		}
+
+		if tx != nil && tx.Status == models.TransactionStatusConfirming && tx.Confirmations >= s.config.MinConfirmations {
+			readyTransactions = append(readyTransactions, *tx)
+		}
+	}
+
+	return readyTransactions, nil
+}
+
+// processTransaction сохраняет или обновляет незачисленное пополнение выходом output.
+// Для уже обработанных выходов возвращает nil.
+func (s *Service) processTransaction(ctx context.Context, userID int64, output chain.Output, confirmations int64) (*models.Transaction, error) {
+	vout := int64(output.Vout)
+	existingTx, err := s.repo.GetTransaction(ctx, output.TxID, vout)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get BTC/RUB rate: %v", err)
+		return nil, fmt.Errorf("failed to check existing transaction: %w", err)
+	}
+	if existingTx != nil && (existingTx.Vout != vout || isFinalTransactionStatus(existingTx.Status)) {
+		return nil, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("bad response from Binance: %s", string(body))
+	status := models.TransactionStatusPending
+	if confirmations > 0 {
+		status = models.TransactionStatusConfirming
+	}
+	if confirmations >= s.config.MinConfirmations && output.Value < s.config.DustLimitSats {
+		s.logger.Infof("Output %s:%d to %s is below dust limit, ignoring", output.TxID, vout, output.Address)
+		status = models.TransactionStatusIgnored
 	}
 
-	var data struct {
-		Symbol string `json:"symbol"`
-		Price  string `json:"price"`
+	tx := &models.Transaction{
+		TxID:          output.TxID,
+		Vout:          vout,
+		UserID:        userID,
+		Address:       output.Address,
+		AmountSats:    output.Value,
+		Confirmed:     confirmations > 0,
+		Confirmations: confirmations,
+		Status:        status,
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0, fmt.Errorf("failed to parse Binance response: %v", err)
+	if existingTx != nil {
+		tx.CreatedAt = existingTx.CreatedAt
 	}
 
-	rate, err := strconv.ParseFloat(data.Price, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid price format: %v", err)
+	if err := s.repo.CreateOrUpdateTransaction(ctx, tx, nil); err != nil {
+		return nil, fmt.Errorf("failed to save transaction: %v", err)
 	}
 
-	return rate, nil
+	return tx, nil
+}
+
+// isFinalTransactionStatus сообщает, что транзакция уже обработана проверкой адреса:
+// зачислена, отложена до накопления минимальной суммы или проигнорирована как пыль.
+func isFinalTransactionStatus(status string) bool {
+	switch status {
+	case models.TransactionStatusCredited, models.TransactionStatusHeld, models.TransactionStatusIgnored:
+		return true
+	}
+	return false
 }
@@ -4,20 +4,94 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"time"
 
+	"github.com/Fi44er/btc_bot/internal/fees"
 	"github.com/Fi44er/btc_bot/internal/models"
+	"github.com/Fi44er/btc_bot/internal/money"
+	"gorm.io/gorm"
 )
 
+// ErrWithdrawalStatus — действие недоступно в текущем статусе заявки на вывод.
+var ErrWithdrawalStatus = errors.New("недопустимый статус заявки")
+
+// withdrawalActor — кто меняет статус заявки; записывается в историю переходов.
+type withdrawalActor struct {
+	role    string
+	id      int64
+	comment string
+	// Подтверждено, что записанного перевода не было: заявку можно отменить и из WithdrawalVoidableStatuses
+	voidsPayout bool
+}
+
+func adminActor(adminID int64) withdrawalActor {
+	return withdrawalActor{role: models.WithdrawalActorAdmin, id: adminID}
+}
+
+func userActor(userID int64) withdrawalActor {
+	return withdrawalActor{role: models.WithdrawalActorUser, id: userID}
+}
+
+// Поля с временем перехода в статус
+var withdrawalStatusTimes = map[string]string{
+	models.WithdrawalStatusApproved:  "approved_at",
+	models.WithdrawalStatusCompleted: "completed_at",
+	models.WithdrawalStatusCanceled:  "canceled_at",
+}
+
 func (s *Service) GetPendingWithdrawalByUserID(ctx context.Context, userID int64) (*models.Withdrawal, error) {
 	return s.repo.GetPendingWithdrawalByUserID(ctx, userID)
 }
 
+// DeleteWithdrawal удаляет заявку. Резерв по незавершённой заявке сначала возвращается на баланс.
 func (s *Service) DeleteWithdrawal(ctx context.Context, id int64) error {
+	withdrawal, err := s.repo.GetWithdrawalByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if withdrawal != nil && slices.Contains(models.WithdrawalOpenStatuses, withdrawal.Status) {
+		actor := withdrawalActor{role: models.WithdrawalActorSystem, comment: "удаление заявки"}
+		if err := s.moveWithdrawal(ctx, id, models.WithdrawalStatusCanceled, actor, nil, releaseWithdrawal); err != nil {
+			return err
+		}
+	}
 	return s.repo.DeleteWithdrawal(ctx, id)
 }
 
-func (s *Service) UpdateUserBalance(ctx context.Context, userID int64, newBalance float64) error {
-	return s.repo.UpdateUserBalance(ctx, userID, newBalance)
+// GetReservedBalance возвращает сумму с комиссией, зарезервированную незавершёнными заявками пользователя
+// на вывод, по счёту резерва в журнале в текущей валюте пользователя.
+func (s *Service) GetReservedBalance(ctx context.Context, userID int64) (money.Amount, error) {
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, fmt.Errorf("пользователь с telegram_id %d не найден", userID)
+	}
+	return s.repo.SumUserReserved(ctx, userID, user.Currency)
+}
+
+// withdrawalEntry собирает запись журнала по заявке на вывод из проводок postings.
+func withdrawalEntry(kind string, withdrawal *models.Withdrawal, key, description string, postings ...models.LedgerPosting) *models.LedgerEntry {
+	return &models.LedgerEntry{
+		Kind:         kind,
+		Currency:     withdrawal.Currency,
+		WithdrawalID: &withdrawal.ID,
+		Key:          &key,
+		Description:  description,
+		Postings:     postings,
+	}
+}
+
+func withdrawalPosting(account string, withdrawal *models.Withdrawal, amount money.Amount) models.LedgerPosting {
+	posting := models.LedgerPosting{Account: account, Currency: withdrawal.Currency, Amount: amount}
+	if account == models.LedgerAccountUser || account == models.LedgerAccountReserved {
+		userID := withdrawal.UserID
+		posting.UserID = &userID
+	}
+	return posting
 }
 
 func (s *Service) GetPendingWithdrawals(ctx context.Context) ([]*models.Withdrawal, error) {
@@ -36,36 +110,148 @@ func (s *Service) GetWithdrawalByID(ctx context.Context, id int64) (*models.With
 	return withdrawal, nil
 }
 
-func (s *Service) UpdateWithdrawalStatus(ctx context.Context, id int64, status string) error {
-	validStatuses := map[string]bool{
-		"pending":   true,
-		"completed": true,
-		"canceled":  true,
-	}
-
-	if !validStatuses[status] {
+// UpdateWithdrawalStatus одобряет, завершает или отменяет заявку вместе с её резервом по решению администратора adminID.
+func (s *Service) UpdateWithdrawalStatus(ctx context.Context, adminID, id int64, status string) error {
+	switch status {
+	case models.WithdrawalStatusApproved:
+		return s.ApproveWithdrawal(ctx, adminID, id)
+	case models.WithdrawalStatusCompleted:
+		return s.ProcessWithdrawal(ctx, adminID, id)
+	case models.WithdrawalStatusCanceled:
+		return s.CancelWithdrawal(ctx, adminID, id)
+	default:
 		return errors.New("invalid withdrawal status")
 	}
+}
+
+// GetWithdrawalTransitions возвращает историю статусов заявки.
+func (s *Service) GetWithdrawalTransitions(ctx context.Context, withdrawalID uint) ([]models.WithdrawalTransition, error) {
+	return s.repo.GetWithdrawalTransitions(ctx, withdrawalID)
+}
+
+// ApproveWithdrawal одобряет заявку: администратор берётся перевести её сумму на карту.
+func (s *Service) ApproveWithdrawal(ctx context.Context, adminID, withdrawalID int64) error {
+	return s.moveWithdrawal(ctx, withdrawalID, models.WithdrawalStatusApproved, adminActor(adminID), nil, nil)
+}
+
+// ProcessWithdrawal списывает зарезервированные сумму и комиссию заявки, перевод по которой
+// пользователь подтвердил, и завершает её в одной транзакции БД. Заявка блокируется на время обработки, поэтому повторный вызов не спишет её дважды.
+// Часть суммы, не попавшая в резерв (заявки, созданные до резервирования), списывается с баланса.
+func (s *Service) ProcessWithdrawal(ctx context.Context, adminID, withdrawalID int64) error {
+	return s.completeWithdrawal(ctx, withdrawalID, adminActor(adminID))
+}
+
+func (s *Service) completeWithdrawal(ctx context.Context, withdrawalID int64, actor withdrawalActor) error {
+	return s.moveWithdrawal(ctx, withdrawalID, models.WithdrawalStatusCompleted, actor, nil, func(withdrawal *models.Withdrawal, held money.Amount) []*models.LedgerEntry {
+		// spend списывает amount на системный счёт account сначала из резерва, затем с баланса
+		spend := func(account string, amount money.Amount) []models.LedgerPosting {
+			postings := []models.LedgerPosting{withdrawalPosting(account, withdrawal, amount)}
+			fromHold := min(held, amount)
+			held -= fromHold
+			if fromHold > 0 {
+				postings = append(postings, withdrawalPosting(models.LedgerAccountReserved, withdrawal, -fromHold))
+			}
+			if rest := amount - fromHold; rest != 0 {
+				postings = append(postings, withdrawalPosting(models.LedgerAccountUser, withdrawal, -rest))
+			}
+			return postings
+		}
 
-	err := s.repo.UpdateWithdrawalStatus(ctx, id, status)
+		entries := []*models.LedgerEntry{
+			withdrawalEntry(models.LedgerEntryWithdrawal, withdrawal, fmt.Sprintf("withdrawal:%d", withdrawal.ID),
+				fmt.Sprintf("вывод #%d на карту %s", withdrawal.ID, withdrawal.CardNumber),
+				spend(models.LedgerAccountPayouts, withdrawal.Amount)...),
+		}
+		if withdrawal.Fee > 0 {
+			entries = append(entries, withdrawalEntry(models.LedgerEntryFee, withdrawal, fmt.Sprintf("fee:%d", withdrawal.ID),
+				fmt.Sprintf("комиссия за вывод #%d", withdrawal.ID),
+				spend(models.LedgerAccountFees, withdrawal.Fee)...))
+		}
+		// Остаток резерва сверх суммы с комиссией возвращается на баланс
+		if held > 0 {
+			entries = append(entries, withdrawalEntry(models.LedgerEntryRelease, withdrawal, fmt.Sprintf("release:%d", withdrawal.ID),
+				fmt.Sprintf("остаток резерва по выводу #%d", withdrawal.ID),
+				withdrawalPosting(models.LedgerAccountReserved, withdrawal, -held),
+				withdrawalPosting(models.LedgerAccountUser, withdrawal, held),
+			))
+		}
+		return entries
+	})
+}
+
+// CancelWithdrawal отклоняет незавершённую заявку и возвращает её резерв на баланс в одной транзакции БД.
+// Заявку с записанным переводом так не отменить, см. VoidPayout.
+func (s *Service) CancelWithdrawal(ctx context.Context, adminID, withdrawalID int64) error {
+	return s.moveWithdrawal(ctx, withdrawalID, models.WithdrawalStatusCanceled, adminActor(adminID), nil, releaseWithdrawal)
+}
+
+// VoidPayout отменяет оспоренную заявку, когда администратор adminID подтвердил, что записанного
+// перевода на самом деле не было, и возвращает её резерв на баланс.
+func (s *Service) VoidPayout(ctx context.Context, adminID, withdrawalID int64) error {
+	withdrawal, err := s.repo.GetWithdrawalByID(ctx, withdrawalID)
 	if err != nil {
-		return fmt.Errorf("failed to update withdrawal status: %w", err)
+		return err
 	}
-	return nil
+	if withdrawal == nil {
+		return errors.New("withdrawal not found")
+	}
+	// Неоспоренный перевод отменяется только решением спора
+	if withdrawal.Status != models.WithdrawalStatusDisputed {
+		return fmt.Errorf("%w: заявка #%d в статусе %s", ErrWithdrawalStatus, withdrawal.ID, withdrawal.Status)
+	}
+
+	actor := adminActor(adminID)
+	actor.comment = "перевода не было"
+	actor.voidsPayout = true
+	return s.moveWithdrawal(ctx, withdrawalID, models.WithdrawalStatusCanceled, actor, nil, voidWithdrawal(actor.comment))
+}
+
+// releaseWithdrawal возвращает на баланс резерв held отменяемой заявки.
+func releaseWithdrawal(withdrawal *models.Withdrawal, held money.Amount) []*models.LedgerEntry {
+	return voidWithdrawal("")(withdrawal, held)
 }
 
-func (s *Service) ProcessWithdrawal(ctx context.Context, withdrawalID int64) error {
+// voidWithdrawal, как и releaseWithdrawal, возвращает резерв отменяемой заявки, указывая в записи журнала причину reason.
+func voidWithdrawal(reason string) func(withdrawal *models.Withdrawal, held money.Amount) []*models.LedgerEntry {
+	return func(withdrawal *models.Withdrawal, held money.Amount) []*models.LedgerEntry {
+		if held == 0 {
+			return nil
+		}
+		description := fmt.Sprintf("отмена вывода #%d", withdrawal.ID)
+		if reason != "" {
+			description += ": " + reason
+		}
+		return []*models.LedgerEntry{withdrawalEntry(models.LedgerEntryRelease, withdrawal, fmt.Sprintf("release:%d", withdrawal.ID),
+			description,
+			withdrawalPosting(models.LedgerAccountReserved, withdrawal, -held),
+			withdrawalPosting(models.LedgerAccountUser, withdrawal, held),
+		)}
+	}
+}
+
+// moveWithdrawal переводит заявку в status по решению actor, обновляя заодно поля fields,
+// и проводит записи, собранные entries по текущему резерву заявки; entries может быть nil, если проводить нечего.
+// Заявка с открытым спором заморожена: переход отклоняется с ErrDisputeOpen.
+func (s *Service) moveWithdrawal(
+	ctx context.Context,
+	withdrawalID int64,
+	status string,
+	actor withdrawalActor,
+	fields map[string]interface{},
+	entries func(withdrawal *models.Withdrawal, held money.Amount) []*models.LedgerEntry,
+) error {
 	tx, err := s.repo.BeginTransaction(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	committed := false
 	defer func() {
-		if err != nil {
+		if !committed {
 			s.repo.Rollback(tx)
 		}
 	}()
 
-	withdrawal, err := s.repo.GetWithdrawalByID(ctx, withdrawalID)
+	withdrawal, err := s.repo.LockWithdrawal(ctx, withdrawalID, tx)
 	if err != nil {
 		return fmt.Errorf("failed to get withdrawal: %w", err)
 	}
@@ -74,50 +260,177 @@ func (s *Service) ProcessWithdrawal(ctx context.Context, withdrawalID int64) err
 		return errors.New("withdrawal not found")
 	}
 
-	if withdrawal.Status != "pending" {
-		return errors.New("withdrawal already processed")
+	dispute, err := s.repo.GetOpenWithdrawalDispute(ctx, withdrawal.ID, s.repo.WithTransaction(tx))
+	if err != nil {
+		return err
+	}
+	if dispute != nil {
+		return fmt.Errorf("%w: по заявке #%d открыт спор #%d", ErrDisputeOpen, withdrawal.ID, dispute.ID)
 	}
 
-	user, err := s.repo.GetUser(ctx, withdrawal.UserID)
-	if err != nil {
-		return fmt.Errorf("failed to get user: %w", err)
+	if err := s.applyWithdrawalMove(ctx, tx, withdrawal, status, actor, fields, entries); err != nil {
+		return err
 	}
 
-	if user == nil {
-		return errors.New("user not found")
+	if err := s.repo.Commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	committed = true
 
-	if user.Balance < withdrawal.Amount {
-		return errors.New("insufficient funds")
+	return nil
+}
+
+// applyWithdrawalMove в транзакции tx проводит записи entries по заблокированной заявке,
+// переводит её в статус status с полями fields и записывает переход в историю.
+// Переходы, которых нет в models.WithdrawalTransitions, отклоняются с ErrWithdrawalStatus,
+// кроме отмены заявки с записанным переводом, когда actor подтвердил, что перевода не было.
+func (s *Service) applyWithdrawalMove(
+	ctx context.Context,
+	tx *gorm.DB,
+	withdrawal *models.Withdrawal,
+	status string,
+	actor withdrawalActor,
+	fields map[string]interface{},
+	entries func(withdrawal *models.Withdrawal, held money.Amount) []*models.LedgerEntry,
+) error {
+	voided := actor.voidsPayout && status == models.WithdrawalStatusCanceled &&
+		slices.Contains(models.WithdrawalVoidableStatuses, withdrawal.Status)
+	if !models.CanMoveWithdrawal(withdrawal.Status, status) && !voided {
+		return fmt.Errorf("%w: заявка #%d в статусе %s", ErrWithdrawalStatus, withdrawal.ID, withdrawal.Status)
 	}
 
-	newBalance := user.Balance - withdrawal.Amount
-	err = s.repo.WithTransaction(tx).
-		Model(&models.User{}).
-		Where("telegram_id = ?", user.TelegramID).
-		Update("balance", newBalance).
-		Error
-	if err != nil {
-		return fmt.Errorf("failed to update user balance: %w", err)
+	if entries != nil {
+		held, err := s.repo.SumWithdrawalHold(ctx, withdrawal.ID, s.repo.WithTransaction(tx))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries(withdrawal, held) {
+			if err := s.postEntry(ctx, entry, s.repo.WithTransaction(tx)); err != nil {
+				return fmt.Errorf("failed to update user balance: %w", err)
+			}
+		}
 	}
 
-	err = s.repo.WithTransaction(tx).
+	now := time.Now()
+	updates := map[string]interface{}{"status": status}
+	if column, ok := withdrawalStatusTimes[status]; ok {
+		updates[column] = now
+	}
+	maps.Copy(updates, fields)
+	err := s.repo.WithTransaction(tx).
 		Model(&models.Withdrawal{}).
 		Where("id = ?", withdrawal.ID).
-		Update("status", "completed").
+		Updates(updates).
 		Error
 	if err != nil {
 		return fmt.Errorf("failed to update withdrawal status: %w", err)
 	}
 
-	if err = s.repo.Commit(tx); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	transition := &models.WithdrawalTransition{
+		WithdrawalID: withdrawal.ID,
+		FromStatus:   withdrawal.Status,
+		ToStatus:     status,
+		Actor:        actor.role,
+		ActorID:      actor.id,
+		Comment:      actor.comment,
+		CreatedAt:    now,
+	}
+	return s.repo.CreateWithdrawalTransition(ctx, transition, s.repo.WithTransaction(tx))
+}
+
+// PreviewWithdrawal показывает, какой станет заявка пользователя, если добавить к ней amount
+// способом method: сумму к выплате, комиссию и общее списание. Ничего не сохраняет.
+func (s *Service) PreviewWithdrawal(ctx context.Context, userID int64, method string, amount money.Amount) (*models.Withdrawal, error) {
+	if amount <= 0 {
+		return nil, errors.New("сумма вывода должна быть положительной")
+	}
+
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить данные пользователя: %w", err)
+	}
+	if user == nil {
+		return nil, errors.New("пользователь не найден")
+	}
+
+	preview := &models.Withdrawal{
+		UserID:     userID,
+		CardNumber: user.CardNumber,
+		Method:     method,
+		Amount:     amount,
+		Currency:   user.Currency,
+		Status:     models.WithdrawalStatusPending,
 	}
 
+	existing, err := s.repo.GetPendingWithdrawalByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось проверить существующие заявки: %w", err)
+	}
+	if existing != nil {
+		if err := canMergeWithdrawal(existing, method); err != nil {
+			return nil, err
+		}
+		if err := s.checkNoOpenWithdrawalDispute(ctx, existing, nil); err != nil {
+			return nil, err
+		}
+		preview.ID = existing.ID
+		preview.Amount += existing.Amount
+	}
+
+	if preview.Fee, err = s.fees.Fee(method, preview.Currency, preview.Amount); err != nil {
+		return nil, err
+	}
+
+	limits, err := s.withdrawalLimits(ctx, user, nil)
+	if err != nil {
+		return nil, err
+	}
+	debit := preview.Amount + preview.Fee
+	if existing != nil {
+		debit -= existing.Amount + existing.Fee
+	}
+	if err := checkWithdrawalLimits(limits, preview.Amount, debit); err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// canMergeWithdrawal проверяет, можно ли добавить сумму к незавершённой заявке.
+func canMergeWithdrawal(existing *models.Withdrawal, method string) error {
+	if existing.Status != models.WithdrawalStatusPending {
+		// Одобренную заявку администратор уже переводит, её сумму менять нельзя
+		return fmt.Errorf("%w: по заявке #%d уже идёт выплата, дождитесь её завершения", ErrWithdrawalStatus, existing.ID)
+	}
+	if existing.Method != method {
+		return fmt.Errorf("%w: заявка #%d оформлена другим способом выплаты", ErrWithdrawalStatus, existing.ID)
+	}
 	return nil
 }
 
+// checkNoOpenWithdrawalDispute отклоняет изменение заявки, по которой открыт спор: до его решения она заморожена.
+func (s *Service) checkNoOpenWithdrawalDispute(ctx context.Context, withdrawal *models.Withdrawal, tx *gorm.DB) error {
+	dispute, err := s.repo.GetOpenWithdrawalDispute(ctx, withdrawal.ID, tx)
+	if err != nil {
+		return err
+	}
+	if dispute != nil {
+		return fmt.Errorf("%w: по заявке #%d открыт спор #%d, дополнить её можно после его решения", ErrDisputeOpen, withdrawal.ID, dispute.ID)
+	}
+	return nil
+}
+
+// CreateOrUpdateWithdrawal создаёт заявку на вывод или увеличивает незавершённую заявку пользователя,
+// пересчитывает комиссию по итоговой сумме и резервирует сумму с комиссией. Резерв проверяет остаток
+// при списании, поэтому зарезервировать больше доступного баланса нельзя даже параллельными запросами.
 func (s *Service) CreateOrUpdateWithdrawal(ctx context.Context, withdrawalDelta *models.Withdrawal) (*models.Withdrawal, bool, error) {
+	if withdrawalDelta.Amount <= 0 {
+		return nil, false, errors.New("сумма вывода должна быть положительной")
+	}
+	if withdrawalDelta.Method == "" {
+		withdrawalDelta.Method = fees.MethodCard
+	}
+
 	user, err := s.repo.GetUser(ctx, withdrawalDelta.UserID)
 	if err != nil {
 		return nil, false, fmt.Errorf("не удалось получить данные пользователя: %w", err)
@@ -131,30 +444,98 @@ func (s *Service) CreateOrUpdateWithdrawal(ctx context.Context, withdrawalDelta
 		return nil, false, fmt.Errorf("не удалось проверить существующие заявки: %w", err)
 	}
 
-	if existingWithdrawal != nil {
-		totalAmount := existingWithdrawal.Amount + withdrawalDelta.Amount
-
-		if totalAmount > user.Balance {
-			return nil, false, fmt.Errorf("недостаточно средств. Итоговая сумма заявки (%.8f) превысит ваш баланс (%.8f)", totalAmount, user.Balance)
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.repo.Rollback(tx)
 		}
+	}()
 
-		existingWithdrawal.Amount = totalAmount
-		if err := s.repo.UpdateWithdrawal(ctx, existingWithdrawal); err != nil {
-			return nil, false, fmt.Errorf("не удалось обновить заявку в базе данных: %w", err)
+	withdrawal := withdrawalDelta
+	var heldBefore money.Amount
+	updated := existingWithdrawal != nil
+	if updated {
+		withdrawal, err = s.repo.LockWithdrawal(ctx, int64(existingWithdrawal.ID), tx)
+		if err != nil {
+			return nil, false, fmt.Errorf("не удалось проверить существующие заявки: %w", err)
+		}
+		if withdrawal == nil {
+			return nil, false, fmt.Errorf("%w: заявка #%d уже обработана", ErrWithdrawalStatus, existingWithdrawal.ID)
 		}
+		if err := canMergeWithdrawal(withdrawal, withdrawalDelta.Method); err != nil {
+			return nil, false, err
+		}
+		if err := s.checkNoOpenWithdrawalDispute(ctx, withdrawal, s.repo.WithTransaction(tx)); err != nil {
+			return nil, false, err
+		}
+
+		heldBefore = withdrawal.Amount + withdrawal.Fee
+		withdrawal.Amount += withdrawalDelta.Amount
+	} else {
+		withdrawal.Status = models.WithdrawalStatusPending
+		withdrawal.Currency = user.Currency
+	}
+
+	if withdrawal.Fee, err = s.fees.Fee(withdrawal.Method, withdrawal.Currency, withdrawal.Amount); err != nil {
+		return nil, false, err
+	}
 
-		return existingWithdrawal, true, nil
+	// Блокировка пользователя (после заявки, как и при её проведении) не даёт параллельным
+	// заявкам вместе превысить его лимиты; общие лимиты проверяются без блокировки
+	if _, err := s.repo.LockUser(ctx, user.TelegramID, s.repo.WithTransaction(tx)); err != nil {
+		return nil, false, err
+	}
+	limits, err := s.withdrawalLimits(ctx, user, s.repo.WithTransaction(tx))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := checkWithdrawalLimits(limits, withdrawal.Amount, withdrawal.Amount+withdrawal.Fee-heldBefore); err != nil {
+		return nil, false, err
+	}
 
+	if updated {
+		err = s.repo.UpdateWithdrawal(ctx, withdrawal, s.repo.WithTransaction(tx))
 	} else {
-		if withdrawalDelta.Amount > user.Balance {
-			return nil, false, fmt.Errorf("недостаточно средств. Запрашиваемая сумма (%.8f) превышает ваш баланс (%.8f)", withdrawalDelta.Amount, user.Balance)
+		err = s.repo.CreateWithdrawal(ctx, withdrawal, s.repo.WithTransaction(tx))
+		if err == nil {
+			err = s.repo.CreateWithdrawalTransition(ctx, &models.WithdrawalTransition{
+				WithdrawalID: withdrawal.ID,
+				ToStatus:     models.WithdrawalStatusPending,
+				Actor:        models.WithdrawalActorUser,
+				ActorID:      withdrawal.UserID,
+				CreatedAt:    withdrawal.CreatedAt,
+			}, s.repo.WithTransaction(tx))
 		}
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("не удалось сохранить заявку в базе данных: %w", err)
+	}
 
-		withdrawalDelta.Status = "pending" // Устанавливаем статус
-		if err := s.repo.CreateWithdrawal(ctx, withdrawalDelta); err != nil {
-			return nil, false, fmt.Errorf("не удалось создать заявку в базе данных: %w", err)
+	// Резервируем разницу: при переходе на другой уровень комиссия может и уменьшиться
+	if delta := withdrawal.Amount + withdrawal.Fee - heldBefore; delta != 0 {
+		hold := withdrawalEntry(models.LedgerEntryHold, withdrawal,
+			fmt.Sprintf("hold:%d:%s", withdrawal.ID, withdrawal.Amount),
+			fmt.Sprintf("резерв по заявке на вывод #%d", withdrawal.ID),
+			withdrawalPosting(models.LedgerAccountUser, withdrawal, -delta),
+			withdrawalPosting(models.LedgerAccountReserved, withdrawal, delta),
+		)
+		if err := s.postEntry(ctx, hold, s.repo.WithTransaction(tx)); err != nil {
+			if errors.Is(err, models.ErrInsufficientFunds) {
+				return nil, false, fmt.Errorf("недостаточно средств. Сумма с комиссией (%s) превышает доступный баланс (%s): %w",
+					delta, user.Balance, err)
+			}
+			return nil, false, err
 		}
+	}
 
-		return withdrawalDelta, false, nil
+	if err := s.repo.Commit(tx); err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	committed = true
+
+	return withdrawal, updated, nil
 }